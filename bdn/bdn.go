@@ -0,0 +1,119 @@
+// Package bdn converts between PGS streams and BDN XML, the event list
+// with PNG images exchanged by Blu-ray authoring tools and BDSup2Sub.
+package bdn
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Format is the video format of the timecodes and screen of a BDN file.
+type Format struct {
+	VideoFormat string // 480i, 480p, 576i, 576p, 720p, 1080i, or 1080p
+	FrameRate   pgs.Rate
+	DropFrame   bool // Timecodes use drop-frame counting, for NTSC rates
+}
+
+var videoFormats = []struct {
+	name          string
+	width, height int
+}{
+	{"480i", 720, 480},
+	{"480p", 720, 480},
+	{"576i", 720, 576},
+	{"576p", 720, 576},
+	{"720p", 1280, 720},
+	{"1080i", 1920, 1080},
+	{"1080p", 1920, 1080},
+}
+
+// IsVideoFormat reports whether the name is a recognized video format.
+func IsVideoFormat(name string) bool {
+	_, _, ok := videoSize(name)
+	return ok
+}
+
+func videoSize(name string) (width, height int, ok bool) {
+	for _, f := range videoFormats {
+		if f.name == name {
+			return f.width, f.height, true
+		}
+	}
+	return 0, 0, false
+}
+
+// DefaultFormat returns the format of a presentation composition. The
+// video format is selected by height, preferring progressive, and the
+// frame rate defaults to 24000/1001 when the code is not recognized.
+func DefaultFormat(pc *pgs.PresentationComposition) Format {
+	var f Format
+	for _, vf := range videoFormats {
+		if vf.height == int(pc.Height) {
+			f.VideoFormat = vf.name
+			if vf.name[len(vf.name)-1] == 'p' {
+				break
+			}
+		}
+	}
+	if f.VideoFormat == "" {
+		f.VideoFormat = "1080p"
+	}
+	r, ok := pc.FrameRate.Rate()
+	if !ok {
+		r = pgs.Rate{Num: 24000, Den: 1001}
+	}
+	f.FrameRate = r
+	return f
+}
+
+func (f *Format) validate() error {
+	if !IsVideoFormat(f.VideoFormat) {
+		return fmt.Errorf("unrecognized video format: %q", f.VideoFormat)
+	}
+	_, _, err := timebase(f.FrameRate, f.DropFrame)
+	return err
+}
+
+// bdnXML is the root element of a BDN file.
+type bdnXML struct {
+	XMLName     xml.Name `xml:"BDN"`
+	Version     string   `xml:",attr"`
+	Description struct {
+		Name struct {
+			Title   string `xml:",attr"`
+			Content string `xml:",attr"`
+		}
+		Language struct {
+			Code string `xml:",attr"`
+		}
+		Format struct {
+			VideoFormat string `xml:",attr"`
+			FrameRate   string `xml:",attr"`
+			DropFrame   string `xml:",attr"`
+		}
+		Events struct {
+			Type           string `xml:",attr"`
+			FirstEventInTC string `xml:",attr"`
+			LastEventOutTC string `xml:",attr"`
+			NumberofEvents int    `xml:",attr"`
+		}
+	}
+	Events []eventXML `xml:"Events>Event"`
+}
+
+type eventXML struct {
+	InTC     string       `xml:",attr"`
+	OutTC    string       `xml:",attr"`
+	Forced   string       `xml:",attr"`
+	Graphics []graphicXML `xml:"Graphic"`
+}
+
+type graphicXML struct {
+	Width  int    `xml:",attr"`
+	Height int    `xml:",attr"`
+	X      int    `xml:",attr"`
+	Y      int    `xml:",attr"`
+	File   string `xml:",chardata"`
+}
@@ -0,0 +1,103 @@
+package bdn
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Export writes the events as a BDN XML file and writes the image of
+// each event as a PNG file in the same directory, named after the XML
+// file. Times are rounded to the nearest frame and events still shown
+// at the end of the stream last one frame.
+func Export(filename string, events []pgs.Subtitle, f Format) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	dir := filepath.Dir(filename)
+	base := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+
+	var doc bdnXML
+	doc.Version = "0.93"
+	doc.Description.Name.Title = base
+	doc.Description.Language.Code = "und"
+	doc.Description.Format.VideoFormat = f.VideoFormat
+	doc.Description.Format.FrameRate = formatRate(f.FrameRate)
+	doc.Description.Format.DropFrame = "False"
+	if f.DropFrame {
+		doc.Description.Format.DropFrame = "True"
+	}
+	doc.Description.Events.Type = "Graphic"
+	doc.Description.Events.NumberofEvents = len(events)
+
+	for i, ev := range events {
+		in := f.FrameRate.Frame(ev.In)
+		out := f.FrameRate.Frame(ev.Out)
+		if out <= in {
+			out = in + 1
+		}
+		inTC, err := FormatTimecode(in, f.FrameRate, f.DropFrame)
+		if err != nil {
+			return fmt.Errorf("event %d: %w", i+1, err)
+		}
+		outTC, err := FormatTimecode(out, f.FrameRate, f.DropFrame)
+		if err != nil {
+			return fmt.Errorf("event %d: %w", i+1, err)
+		}
+		name := fmt.Sprintf("%s_%04d.png", base, i+1)
+		if err := writePNG(filepath.Join(dir, name), ev.Image); err != nil {
+			return err
+		}
+		size := ev.Image.Bounds().Size()
+		doc.Events = append(doc.Events, eventXML{
+			InTC:   inTC,
+			OutTC:  outTC,
+			Forced: "False",
+			Graphics: []graphicXML{{
+				Width:  size.X,
+				Height: size.Y,
+				X:      ev.X,
+				Y:      ev.Y,
+				File:   name,
+			}},
+		})
+	}
+	if n := len(doc.Events); n != 0 {
+		doc.Description.Events.FirstEventInTC = doc.Events[0].InTC
+		doc.Description.Events.LastEventOutTC = doc.Events[n-1].OutTC
+	}
+
+	out, err := xml.MarshalIndent(&doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.Write(out)
+	buf.WriteByte('\n')
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// formatRate formats a frame rate as a decimal with at most three
+// places, as used in BDN files.
+func formatRate(r pgs.Rate) string {
+	n := (r.Num*1000 + r.Den/2) / r.Den
+	s := fmt.Sprintf("%d.%03d", n/1000, n%1000)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func writePNG(filename string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
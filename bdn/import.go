@@ -0,0 +1,104 @@
+package bdn
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Import reads the events of a BDN XML file and their PNG images, which
+// are in the same directory. Each of the at most two graphics of an
+// event is a subtitle with the times of the event.
+func Import(filename string) ([]pgs.Subtitle, Format, error) {
+	var f Format
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, f, err
+	}
+	var doc bdnXML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, f, err
+	}
+	format := doc.Description.Format
+	f.VideoFormat = format.VideoFormat
+	f.FrameRate, err = pgs.ParseRate(format.FrameRate)
+	if err != nil {
+		return nil, f, err
+	}
+	f.DropFrame = strings.EqualFold(format.DropFrame, "true")
+	if err := f.validate(); err != nil {
+		return nil, f, err
+	}
+
+	dir := filepath.Dir(filename)
+	var events []pgs.Subtitle
+	for i, ev := range doc.Events {
+		in, err := ParseTimecode(ev.InTC, f.FrameRate, f.DropFrame)
+		if err != nil {
+			return nil, f, fmt.Errorf("event %d: %w", i+1, err)
+		}
+		out, err := ParseTimecode(ev.OutTC, f.FrameRate, f.DropFrame)
+		if err != nil {
+			return nil, f, fmt.Errorf("event %d: %w", i+1, err)
+		}
+		if len(ev.Graphics) == 0 {
+			return nil, f, fmt.Errorf("event %d: no graphics", i+1)
+		}
+		if len(ev.Graphics) > 2 {
+			return nil, f, fmt.Errorf("event %d: %d graphics, at most 2 allowed", i+1, len(ev.Graphics))
+		}
+		for _, g := range ev.Graphics {
+			img, err := readPNG(filepath.Join(dir, g.File))
+			if err != nil {
+				return nil, f, fmt.Errorf("event %d: %w", i+1, err)
+			}
+			if size := img.Bounds().Size(); size.X != g.Width || size.Y != g.Height {
+				return nil, f, fmt.Errorf("event %d: %s: image is %dx%d, graphic is %dx%d",
+					i+1, g.File, size.X, size.Y, g.Width, g.Height)
+			}
+			events = append(events, pgs.Subtitle{
+				In:    f.FrameRate.Time(in),
+				Out:   f.FrameRate.Time(out),
+				X:     g.X,
+				Y:     g.Y,
+				Image: img,
+			})
+		}
+	}
+	return events, f, nil
+}
+
+func readPNG(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// Stream compiles the events into a stream in the format with
+// pgs.NewStream.
+func Stream(events []pgs.Subtitle, f Format, cs pgs.ColorSpace) ([]pgs.DisplaySet, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	width, height, _ := videoSize(f.VideoFormat)
+	code, ok := f.FrameRate.FrameRate()
+	if !ok {
+		return nil, fmt.Errorf("no frame rate code for %s fps", f.FrameRate)
+	}
+	pc := pgs.PresentationComposition{
+		Width:     uint16(width),
+		Height:    uint16(height),
+		FrameRate: code,
+	}
+	return pgs.NewStream(events, pc, cs)
+}
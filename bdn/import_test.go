@@ -0,0 +1,132 @@
+package bdn
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+const testBDN = `<?xml version="1.0" encoding="UTF-8"?>
+<BDN Version="0.93">
+  <Description>
+    <Name Title="test" Content=""/>
+    <Language Code="eng"/>
+    <Format VideoFormat="1080p" FrameRate="25" DropFrame="false"/>
+    <Events Type="Graphic" FirstEventInTC="00:00:01:00" LastEventOutTC="00:00:04:00" NumberofEvents="2"/>
+  </Description>
+  <Events>
+    <Event InTC="00:00:01:00" OutTC="00:00:02:12" Forced="False">
+      <Graphic Width="20" Height="10" X="100" Y="900">line.png</Graphic>
+    </Event>
+    <Event InTC="00:00:03:00" OutTC="00:00:04:00" Forced="False">
+      <Graphic Width="10" Height="10" X="100" Y="50">top.png</Graphic>
+      <Graphic Width="20" Height="10" X="100" Y="900">line.png</Graphic>
+    </Event>
+  </Events>
+</BDN>
+`
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPNG(t, filepath.Join(dir, "line.png"), 20, 10, color.NRGBA{255, 255, 255, 255})
+	writeTestPNG(t, filepath.Join(dir, "top.png"), 10, 10, color.NRGBA{255, 255, 0, 255})
+	filename := filepath.Join(dir, "test.xml")
+	if err := ioutil.WriteFile(filename, []byte(testBDN), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, f, err := Import(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Format{VideoFormat: "1080p", FrameRate: pgs.Rate{Num: 25, Den: 1}}
+	if f != want {
+		t.Errorf("got format %+v, want %+v", f, want)
+	}
+	ms := time.Millisecond
+	wantSubs := []struct {
+		in, out time.Duration
+		rect    image.Rectangle
+	}{
+		{1000 * ms, 2480 * ms, image.Rect(100, 900, 120, 910)},
+		{3000 * ms, 4000 * ms, image.Rect(100, 50, 110, 60)},
+		{3000 * ms, 4000 * ms, image.Rect(100, 900, 120, 910)},
+	}
+	if len(subs) != len(wantSubs) {
+		t.Fatalf("got %d subtitles, want %d", len(subs), len(wantSubs))
+	}
+	for i, sub := range subs {
+		w := wantSubs[i]
+		b := sub.Image.Bounds()
+		rect := b.Sub(b.Min).Add(image.Pt(sub.X, sub.Y))
+		if sub.In != w.in || sub.Out != w.out || rect != w.rect {
+			t.Errorf("subtitle %d: got %s to %s at %v, want %s to %s at %v",
+				i, sub.In, sub.Out, rect, w.in, w.out, w.rect)
+		}
+	}
+
+	stream, err := Stream(subs, f, pgs.ColorSpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, finding := range pgs.ValidateStream(stream) {
+		if finding.Severity == pgs.Error {
+			t.Error(finding)
+		}
+	}
+	for i, ds := range stream {
+		if ds.Width != 1920 || ds.Height != 1080 || ds.FrameRate != pgs.FrameRate25 {
+			t.Errorf("display set %d: %dx%d at %s, want 1920x1080 at 25 fps", i, ds.Width, ds.Height, ds.FrameRate)
+		}
+	}
+	events, err := pgs.Events(stream, pgs.ColorSpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The graphics of an event are each an image
+	wantEvents := [][]int{{0}, {1, 2}}
+	if len(events) != len(wantEvents) {
+		t.Fatalf("got %d events, want %d", len(events), len(wantEvents))
+	}
+	for i, ev := range events {
+		if len(ev.Images) != len(wantEvents[i]) {
+			t.Errorf("event %d: got %d images, want %d", i, len(ev.Images), len(wantEvents[i]))
+			continue
+		}
+		for j, img := range ev.Images {
+			w := wantSubs[wantEvents[i][j]]
+			if ev.Start != w.in || ev.End != w.out || img.Rect() != w.rect {
+				t.Errorf("event %d image %d: got %s to %s at %v, want %s to %s at %v",
+					i, j, ev.Start, ev.End, img.Rect(), w.in, w.out, w.rect)
+			}
+		}
+	}
+	if ds := &stream[2]; len(ds.Windows) != 2 || len(ds.Objects) != 2 {
+		t.Errorf("display set 2: got %d windows and %d objects, want 2 each", len(ds.Windows), len(ds.Objects))
+	}
+}
+
+func writeTestPNG(t *testing.T, filename string, width, height int, c color.Color) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
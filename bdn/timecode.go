@@ -0,0 +1,69 @@
+package bdn
+
+import (
+	"fmt"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// timebase returns the nominal whole frame rate used to count frames in
+// timecodes and the number of frame numbers dropped each minute, except
+// every tenth minute, with drop-frame counting.
+func timebase(r pgs.Rate, dropFrame bool) (fps, drop int64, err error) {
+	if r.Num <= 0 || r.Den <= 0 {
+		return 0, 0, fmt.Errorf("invalid frame rate: %s", r)
+	}
+	fps = (r.Num + r.Den - 1) / r.Den
+	if !dropFrame {
+		return fps, 0, nil
+	}
+	if r.Den != 1001 || r.Num != fps*1000 || fps%30 != 0 {
+		return 0, 0, fmt.Errorf("drop-frame timecode not defined for %s fps", r)
+	}
+	return fps, fps / 15, nil
+}
+
+// FormatTimecode formats a frame number as a HH:MM:SS:FF timecode.
+func FormatTimecode(frame int64, r pgs.Rate, dropFrame bool) (string, error) {
+	fps, drop, err := timebase(r, dropFrame)
+	if err != nil {
+		return "", err
+	}
+	if frame < 0 {
+		return "", fmt.Errorf("negative frame: %d", frame)
+	}
+	if drop != 0 {
+		perMin := fps*60 - drop
+		per10Min := perMin*10 + drop
+		d, m := frame/per10Min, frame%per10Min
+		frame += 9 * drop * d
+		if m > drop {
+			frame += drop * ((m - drop) / perMin)
+		}
+	}
+	sec := frame / fps
+	return fmt.Sprintf("%02d:%02d:%02d:%02d", sec/3600, sec/60%60, sec%60, frame%fps), nil
+}
+
+// ParseTimecode parses a HH:MM:SS:FF timecode as a frame number. The
+// separator before the frames may also be ';' or '.'.
+func ParseTimecode(s string, r pgs.Rate, dropFrame bool) (int64, error) {
+	fps, drop, err := timebase(r, dropFrame)
+	if err != nil {
+		return 0, err
+	}
+	var hh, mm, ss, ff int64
+	var sep byte
+	if n, err := fmt.Sscanf(s, "%2d:%2d:%2d%c%2d", &hh, &mm, &ss, &sep, &ff); err != nil || n != 5 ||
+		len(s) != 11 || sep != ':' && sep != ';' && sep != '.' {
+		return 0, fmt.Errorf("invalid timecode: %q", s)
+	}
+	if mm >= 60 || ss >= 60 || ff >= fps {
+		return 0, fmt.Errorf("timecode out of range: %q", s)
+	}
+	min := hh*60 + mm
+	if drop != 0 && ss == 0 && ff < drop && min%10 != 0 {
+		return 0, fmt.Errorf("timecode dropped in drop-frame counting: %q", s)
+	}
+	return (min*60+ss)*fps + ff - drop*(min-min/10), nil
+}
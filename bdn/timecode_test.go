@@ -0,0 +1,50 @@
+package bdn
+
+import (
+	"testing"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+func TestTimecode(t *testing.T) {
+	ntsc := pgs.Rate{Num: 30000, Den: 1001}
+	tests := []struct {
+		frame     int64
+		rate      pgs.Rate
+		dropFrame bool
+		tc        string
+	}{
+		{0, pgs.Rate{Num: 25, Den: 1}, false, "00:00:00:00"},
+		{90024, pgs.Rate{Num: 25, Den: 1}, false, "01:00:00:24"},
+		{1799, pgs.Rate{Num: 24000, Den: 1001}, false, "00:01:14:23"},
+		{1799, ntsc, true, "00:00:59:29"},
+		{1800, ntsc, true, "00:01:00:02"},
+		{17981, ntsc, true, "00:09:59:29"},
+		{17982, ntsc, true, "00:10:00:00"},
+		{17983, ntsc, true, "00:10:00:01"},
+		{107892, ntsc, true, "01:00:00:00"},
+		{3600, pgs.Rate{Num: 60000, Den: 1001}, true, "00:01:00:04"},
+	}
+	for _, tt := range tests {
+		tc, err := FormatTimecode(tt.frame, tt.rate, tt.dropFrame)
+		if err != nil {
+			t.Errorf("FormatTimecode(%d, %s, %t): %v", tt.frame, tt.rate, tt.dropFrame, err)
+			continue
+		}
+		if tc != tt.tc {
+			t.Errorf("FormatTimecode(%d, %s, %t) = %s, want %s", tt.frame, tt.rate, tt.dropFrame, tc, tt.tc)
+		}
+		frame, err := ParseTimecode(tc, tt.rate, tt.dropFrame)
+		if err != nil {
+			t.Errorf("ParseTimecode(%s, %s, %t): %v", tc, tt.rate, tt.dropFrame, err)
+		} else if frame != tt.frame {
+			t.Errorf("ParseTimecode(%s, %s, %t) = %d, want %d", tc, tt.rate, tt.dropFrame, frame, tt.frame)
+		}
+	}
+	if _, err := ParseTimecode("00:01:00:01", ntsc, true); err == nil {
+		t.Error("dropped timecode parsed without error")
+	}
+	if _, err := FormatTimecode(0, pgs.Rate{Num: 25, Den: 1}, true); err == nil {
+		t.Error("drop-frame timecode at 25 fps formatted without error")
+	}
+}
@@ -1,51 +1,235 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
 	"image/png"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/andrewarchi/transup/bdn"
+	"github.com/andrewarchi/transup/mkv"
 	"github.com/andrewarchi/transup/pgs"
+	"github.com/andrewarchi/transup/pgsjson"
 	"github.com/andrewarchi/transup/trans"
+	"github.com/andrewarchi/transup/ts"
+	"github.com/andrewarchi/transup/vobsub"
 )
 
-const usage = `Usage:
+const usage = `Usage: transup [-recover] <command> <args>
 	transup reverse <filename> <duration> [out]
-	transup dump <filename> <image-dir>`
+	transup shift <filename> <offset>[@<start>][,...] [out]
+	transup trim <filename> <start> <end> [out]
+	transup concat <filename> <filename2> <offset> [out]
+	transup split <filename> <cuts.txt|chapters.xml> <out-prefix>
+	transup merge <filename> <filename2> [out]
+	transup fps <filename> <from> <to> [out]
+	transup retime <filename> [out]
+	transup validate <filename>
+	transup dump <filename> <image-dir> [colorspace]
+	transup segments <filename> [-x]
+	transup tojson <filename> [out.json [-png [colorspace]]]
+	transup fromjson <filename.json> [out]
+	transup tobdn <filename> <out.xml> [fps] [video-format] [-df] [colorspace]
+	transup frombdn <filename.xml> [out] [colorspace]
+	transup tovobsub <filename> <out.idx> [<width>x<height>] [<b>,<p>,<e1>,<e2>] [colorspace]
+	transup fromvobsub <filename.idx> <out> [<width>x<height>] [fps] [colorspace]
+	transup extract <filename.m2ts|mkv> [pid|track] [out]
+	transup mux <filename> <out.m2ts|mkv> [pid] [language]
+	transup render <filename> <time> <out.png> [colorspace]
+
+With -recover, damaged input is repaired where possible, rather than
+failing, and each repair is reported.
+
+Color spaces are auto, bt601, or bt709, optionally with -full for full
+range. The default, auto, selects by video height with limited range.
+
+Shift offsets are durations, such as -1.5s. An offset with @<start>
+applies to display sets from that time until the next offset.
+
+The trim command keeps the display sets between two times, such as 1m
+and 2m30s, starting with an Epoch Start of what is live at the start.
+The concat command appends the second stream shifted by the offset.
+
+The split command cuts the stream at each time in a text file, such as
+00:21:30.500 or 21m30.5s on each line, or at the start of each chapter
+in a Matroska chapter XML file. Each segment is written rebased to zero
+to <out-prefix>01.sup, <out-prefix>02.sup, and so on.
+
+The merge command combines two streams to be shown at once, such as
+subtitles in two languages, one at the top and one at the bottom. Lines
+of the second that overlap those of the first are moved to the top,
+mirroring their position.
+
+The segments command lists every segment with its offset, header, and
+decoded payload, with the raw bytes of its flags, and, with -x, a hex
+dump of the payload.
+
+The tojson command writes the stream as JSON with image data inline
+or, with -png, as PNG files in the directory of out.json. Only inline
+image data converts back to an identical stream with fromjson.
+
+The tobdn command writes a BDN XML file with a PNG for each change of
+the screen. The frame rate and video format, such as 1080p, default to
+those of the stream and -df selects drop-frame timecodes. The frombdn
+command converts a BDN XML file back to a stream, quantizing each image
+to 255 colors.
+
+The tovobsub command writes a VobSub .idx and .sub, optionally scaled
+to another video size, such as 720x480. By default, each subtitle is
+reduced to three colors mapped to the nearest in the palette, or else
+to the given palette indices of the background, pattern, emphasis 1,
+and emphasis 2 colors.
+
+The fromvobsub command converts the default track of a VobSub .idx and
+.sub, optionally upscaled to another video size, such as 1920x1080. The
+frame rate defaults to 29.97 for 480 lines, 25 for 576 lines, and
+23.976 otherwise.
+
+The extract command demuxes a PGS stream from a transport stream, such
+as a Blu-ray .m2ts, by its PID, such as 0x1200, or from a Matroska file
+by its track number, or else the first PGS stream. Input files ending in
+.ts, .m2ts, .mts, .mkv, or .mks are demuxed the same way by every
+command. Matroska does not store decoding times, so they are recomputed.
+The mux command writes a stream as a transport stream with the PID,
+0x1200 by default, and language code, such as eng, with 192-byte packets
+for .m2ts and .mts and 188-byte packets otherwise, or as a Matroska file
+for .mkv and .mks.
+
+Frame rates are fractions, such as 24000/1001, or decimals, such as
+23.976 or 25.`
+
+// recoverInput enables repairing damaged input streams.
+var recoverInput bool
 
 func main() {
-	if len(os.Args) < 4 || len(os.Args) > 5 ||
-		!(os.Args[1] == "reverse" ||
-			(os.Args[1] == "dump" && len(os.Args) == 4)) {
-		fmt.Fprintln(os.Stderr, usage)
-		os.Exit(2)
+	args := os.Args[1:]
+	if len(args) != 0 && args[0] == "-recover" {
+		recoverInput = true
+		args = args[1:]
 	}
-	cmd, filename := os.Args[1], os.Args[2]
-
-	f, err := os.Open(filename)
-	try(err)
-	defer f.Close()
-	stream, err := pgs.NewReader(f).ReadAll()
-	try(err)
+	if len(args) < 2 {
+		exitUsage()
+	}
+	cmd, args := args[0], args[1:]
 
 	switch cmd {
 	case "reverse":
-		out := os.Stdout
-		if len(os.Args) == 5 {
-			out, err = os.Create(os.Args[4])
+		checkArgs(args, 2, 3)
+		stream := readStream(args[0])
+		d, err := time.ParseDuration(args[1])
+		try(err)
+		rev, err := trans.Reverse(stream, d)
+		try(err)
+		writeStream(args[2:], rev)
+	case "shift":
+		checkArgs(args, 2, 3)
+		stream := readStream(args[0])
+		var offsets []trans.Offset
+		for _, arg := range strings.Split(args[1], ",") {
+			var o trans.Offset
+			var err error
+			if i := strings.IndexByte(arg, '@'); i != -1 {
+				o.Start, err = time.ParseDuration(arg[i+1:])
+				try(err)
+				arg = arg[:i]
+			}
+			o.Offset, err = time.ParseDuration(arg)
 			try(err)
+			offsets = append(offsets, o)
 		}
-		d, err := time.ParseDuration(os.Args[3])
+		shifted, err := trans.ShiftRanges(stream, offsets)
 		try(err)
-		rev, err := trans.Reverse(stream, d)
+		writeStream(args[2:], shifted)
+	case "trim":
+		checkArgs(args, 3, 4)
+		stream := readStream(args[0])
+		start, err := time.ParseDuration(args[1])
+		try(err)
+		end, err := time.ParseDuration(args[2])
+		try(err)
+		trimmed, err := trans.Trim(stream, start, end)
+		try(err)
+		writeStream(args[3:], trimmed)
+	case "concat":
+		checkArgs(args, 3, 4)
+		a := readStream(args[0])
+		b := readStream(args[1])
+		offset, err := time.ParseDuration(args[2])
+		try(err)
+		joined, err := trans.Concat(a, b, offset)
+		try(err)
+		writeStream(args[3:], joined)
+	case "split":
+		checkArgs(args, 3, 3)
+		stream := readStream(args[0])
+		f, err := os.Open(args[1])
+		try(err)
+		cuts, err := trans.ReadCuts(f)
+		f.Close()
+		try(err)
+		segments, err := trans.Split(stream, cuts)
+		try(err)
+		width := len(strconv.Itoa(len(segments)))
+		if width < 2 {
+			width = 2
+		}
+		for i, seg := range segments {
+			writeStream([]string{fmt.Sprintf("%s%0*d.sup", args[2], width, i+1)}, seg)
+		}
+	case "merge":
+		checkArgs(args, 2, 3)
+		a := readStream(args[0])
+		b := readStream(args[1])
+		merged, err := trans.Merge(a, b)
+		try(err)
+		writeStream(args[2:], merged)
+	case "fps":
+		checkArgs(args, 3, 4)
+		stream := readStream(args[0])
+		from, err := pgs.ParseRate(args[1])
 		try(err)
-		try(pgs.NewWriter(out).WriteAll(rev))
+		to, err := pgs.ParseRate(args[2])
+		try(err)
+		scaled, err := trans.ScaleTime(stream, from, to)
+		try(err)
+		writeStream(args[3:], scaled)
+	case "retime":
+		checkArgs(args, 1, 2)
+		stream := readStream(args[0])
+		retimed, errs, err := trans.RecomputeDecodingTimes(stream)
+		try(err)
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		writeStream(args[1:], retimed)
+	case "validate":
+		checkArgs(args, 1, 1)
+		stream := readStream(args[0])
+		valid := true
+		for _, f := range pgs.ValidateStream(stream) {
+			fmt.Println(f)
+			if f.Severity == pgs.Error {
+				valid = false
+			}
+		}
+		if !valid {
+			os.Exit(1)
+		}
 	case "dump":
-		dirname := os.Args[3]
+		checkArgs(args, 2, 3)
+		stream := readStream(args[0])
+		dirname := args[1]
+		cs := parseColorSpace(args[2:])
 		try(os.MkdirAll(dirname, 0755))
 		n := 0
+		e := pgs.NewEpoch()
 		for i, ds := range stream {
 			if i != 0 {
 				fmt.Println()
@@ -55,23 +239,417 @@ func main() {
 			if ds.Windows != nil {
 				fmt.Printf("Windows: %+v\n", ds.Windows)
 			}
-			if ds.Palette != nil {
-				fmt.Printf("Palette: %+v\n", ds.Palette)
+			for j := range ds.Palettes {
+				fmt.Printf("Palette: %+v\n", &ds.Palettes[j])
 			}
-			if ds.Object != nil {
+			if err := e.Apply(&ds); err != nil {
+				fmt.Fprintf(os.Stderr, "display set %d: %v\n", i, err)
+			}
+			for _, obj := range ds.Objects {
 				n++
-				fmt.Printf("Object: %+v\n", ds.Object)
-				img, err := ds.Object.Image.Convert(ds.Palette)
+				fmt.Printf("Object: %+v\n", obj)
+				p, ok := e.Palettes[ds.PaletteID]
+				if !ok {
+					fmt.Fprintf(os.Stderr, "object %d: palette %d not defined\n", obj.ID, ds.PaletteID)
+					continue
+				}
+				img, err := obj.Image.Convert(&p, cs.Resolve(ds.Height))
 				try(err)
 				name := fmt.Sprintf("sub_%d_%s.png", n, ds.PresentationTime)
-				f, err := os.Create(filepath.Join(dirname, name))
+				writePNG(filepath.Join(dirname, name), img)
+			}
+		}
+	case "segments":
+		checkArgs(args, 1, 2)
+		hexDump := len(args) == 2
+		if hexDump && args[1] != "-x" {
+			exitUsage()
+		}
+		f, err := os.Open(args[0])
+		try(err)
+		defer f.Close()
+		r := pgs.NewSegmentReader(f)
+		if recoverInput {
+			r = pgs.NewRecoveringSegmentReader(f)
+		}
+		for {
+			s, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			try(err)
+			fmt.Printf("0x%08x %s size:%d pts:%d (%s) dts:%d (%s)\n",
+				s.Offset, s.SegmentType, s.SegmentSize,
+				s.PresentationTime, timecode(s.PresentationTime.Duration()),
+				s.DecodingTime, timecode(s.DecodingTime.Duration()))
+			printPayload(s)
+			if hexDump && len(s.Data) != 0 {
+				d := hex.Dumper(os.Stdout)
+				d.Write(s.Data)
+				d.Close()
+			}
+		}
+		for _, w := range r.Warnings() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], w)
+		}
+	case "tojson":
+		checkArgs(args, 1, 4)
+		if len(args) >= 2 && args[1] == "-png" {
+			exitUsage() // -png requires an explicit out.json
+		}
+		stream := readStream(args[0])
+		if len(args) <= 2 {
+			f := os.Stdout
+			if len(args) == 2 {
+				var err error
+				f, err = os.Create(args[1])
+				try(err)
+				defer f.Close()
+			}
+			try(pgsjson.Encode(f, stream))
+			break
+		}
+		if args[2] != "-png" {
+			exitUsage()
+		}
+		f, err := os.Create(args[1])
+		try(err)
+		defer f.Close()
+		try(pgsjson.EncodePNG(f, stream, filepath.Dir(args[1]), parseColorSpace(args[3:])))
+	case "fromjson":
+		checkArgs(args, 1, 2)
+		f, err := os.Open(args[0])
+		try(err)
+		defer f.Close()
+		stream, err := pgsjson.Decode(f, filepath.Dir(args[0]))
+		try(err)
+		writeStream(args[1:], stream)
+	case "tobdn":
+		checkArgs(args, 2, 6)
+		stream := readStream(args[0])
+		if len(stream) == 0 {
+			try(errors.New("empty stream"))
+		}
+		f := bdn.DefaultFormat(&stream[0].PresentationComposition)
+		var cs pgs.ColorSpace
+		for _, arg := range args[2:] {
+			if arg == "-df" {
+				f.DropFrame = true
+			} else if bdn.IsVideoFormat(arg) {
+				f.VideoFormat = arg
+			} else if c, err := pgs.ParseColorSpace(arg); err == nil {
+				cs = c
+			} else {
+				r, err := pgs.ParseRate(arg)
 				try(err)
-				try(png.Encode(f, img))
+				f.FrameRate = r
 			}
 		}
+		events, err := pgs.Events(stream, cs)
+		try(err)
+		subs := pgs.Subtitles(events)
+		try(bdn.Export(args[1], subs, f))
+	case "frombdn":
+		checkArgs(args, 1, 3)
+		subs, f, err := bdn.Import(args[0])
+		try(err)
+		stream, err := bdn.Stream(subs, f, parseColorSpace(args[2:]))
+		try(err)
+		writeStream(args[1:2], stream)
+	case "tovobsub":
+		checkArgs(args, 2, 5)
+		stream := readStream(args[0])
+		if len(stream) == 0 {
+			try(errors.New("empty stream"))
+		}
+		var opts vobsub.Options
+		var cs pgs.ColorSpace
+		for _, arg := range args[2:] {
+			if strings.Contains(arg, ",") {
+				colors, err := vobsub.ParseColors(arg)
+				try(err)
+				opts.Colors = colors
+			} else if c, err := pgs.ParseColorSpace(arg); err == nil {
+				cs = c
+			} else if n, err := fmt.Sscanf(arg, "%dx%d", &opts.Width, &opts.Height); err != nil || n != 2 {
+				exitUsage()
+			}
+		}
+		events, err := pgs.Events(stream, cs)
+		try(err)
+		subs := pgs.Subtitles(events)
+		pc := &stream[0].PresentationComposition
+		try(vobsub.Export(args[1], subs, image.Pt(int(pc.Width), int(pc.Height)), &opts))
+	case "fromvobsub":
+		checkArgs(args, 2, 5)
+		track, err := vobsub.Import(args[0], -1)
+		try(err)
+		size := track.Screen
+		var rate pgs.Rate
+		var cs pgs.ColorSpace
+		for _, arg := range args[2:] {
+			if n, err := fmt.Sscanf(arg, "%dx%d", &size.X, &size.Y); err == nil && n == 2 {
+				continue
+			} else if c, err := pgs.ParseColorSpace(arg); err == nil {
+				cs = c
+			} else {
+				rate, err = pgs.ParseRate(arg)
+				try(err)
+			}
+		}
+		if rate == (pgs.Rate{}) {
+			switch size.Y {
+			case 480:
+				rate = pgs.Rate{Num: 30000, Den: 1001}
+			case 576:
+				rate = pgs.Rate{Num: 25, Den: 1}
+			default:
+				rate = pgs.Rate{Num: 24000, Den: 1001}
+			}
+		}
+		code, ok := rate.FrameRate()
+		if !ok {
+			try(fmt.Errorf("no frame rate code for %s fps", rate))
+		}
+		subs := vobsub.Scale(track.Subtitles, track.Screen, size)
+		pc := pgs.PresentationComposition{Width: uint16(size.X), Height: uint16(size.Y), FrameRate: code}
+		stream, err := pgs.NewStream(subs, pc, cs)
+		try(err)
+		writeStream(args[1:2], stream)
+	case "extract":
+		checkArgs(args, 1, 3)
+		var id uint64
+		out := args[1:]
+		if len(out) != 0 {
+			if n, err := strconv.ParseUint(out[0], 0, 64); err == nil {
+				id, out = n, out[1:]
+			} else if len(out) == 2 {
+				try(fmt.Errorf("invalid PID or track number: %q", out[0]))
+			}
+		}
+		f, err := os.Open(args[0])
+		try(err)
+		defer f.Close()
+		var stream []pgs.DisplaySet
+		if isMatroska(args[0]) {
+			mr := mkv.NewReader(f, id)
+			stream = retime(args[0], readAll(args[0], mr))
+			for _, t := range mr.Tracks() {
+				mark := " "
+				if t.Number == mr.Number() {
+					mark = "*"
+				}
+				fmt.Fprintf(os.Stderr, "%s track %d (%s)\n", mark, t.Number, t.Language)
+			}
+		} else {
+			if id > 0x1fff {
+				try(fmt.Errorf("invalid PID: %q", args[1]))
+			}
+			tr := ts.NewReader(f, uint16(id))
+			stream = readAll(args[0], tr)
+			for _, s := range tr.Streams() {
+				mark := " "
+				if s.PID == tr.PID() {
+					mark = "*"
+				}
+				fmt.Fprintf(os.Stderr, "%s %s\n", mark, s)
+			}
+		}
+		writeStream(out, stream)
+	case "mux":
+		checkArgs(args, 2, 4)
+		var mux func(w io.Writer, stream []pgs.DisplaySet) error
+		if isMatroska(args[1]) {
+			checkArgs(args, 2, 3)
+			opts := &mkv.MuxOptions{}
+			if len(args) == 3 {
+				opts.Language = args[2]
+			}
+			mux = func(w io.Writer, stream []pgs.DisplaySet) error { return mkv.Mux(w, stream, opts) }
+		} else {
+			ext := strings.ToLower(filepath.Ext(args[1]))
+			opts := &ts.MuxOptions{M2TS: ext == ".m2ts" || ext == ".mts"}
+			rest := args[2:]
+			if len(rest) != 0 {
+				if pid, err := strconv.ParseUint(rest[0], 0, 13); err == nil {
+					opts.PID, rest = uint16(pid), rest[1:]
+				} else if len(rest) == 2 {
+					try(fmt.Errorf("invalid PID: %q", rest[0]))
+				}
+			}
+			if len(rest) != 0 {
+				opts.Language = rest[0]
+			}
+			mux = func(w io.Writer, stream []pgs.DisplaySet) error { return ts.Mux(w, stream, opts) }
+		}
+		stream := readStream(args[0])
+		f, err := os.Create(args[1])
+		try(err)
+		defer f.Close()
+		try(mux(f, stream))
+	case "render":
+		checkArgs(args, 3, 4)
+		stream := readStream(args[0])
+		t, err := time.ParseDuration(args[1])
+		try(err)
+		img, err := pgs.NewCompositor(stream).Render(t, parseColorSpace(args[3:]))
+		try(err)
+		writePNG(args[2], img)
+	default:
+		exitUsage()
+	}
+}
+
+// timecode formats a time as hours, minutes, seconds, and milliseconds.
+func timecode(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// printPayload prints the decoded fields of the payload of a segment
+// and the raw bytes of its flags: the palette update flag of a PCS and
+// the cropped flag of each composition object, or the sequence flag of
+// an ODS.
+func printPayload(s *pgs.Segment) {
+	p, err := s.Payload()
+	if err != nil {
+		fmt.Printf("\terror: %v\n", err)
+		return
+	}
+	switch p := p.(type) {
+	case *pgs.PresentationComposition:
+		fmt.Printf("\t%dx%d %s number:%d state:%s palette:%d palette_update:%t (flag 0x%02x)\n",
+			p.Width, p.Height, p.FrameRate, p.CompositionNumber, p.CompositionState,
+			p.PaletteID, p.PaletteUpdate, s.Data[8])
+		off := 11 // Composition objects follow the 11-byte PCS
+		for _, co := range p.CompositionObjects {
+			fmt.Printf("\tobject:%d window:%d x:%d y:%d cropped:%t (flag 0x%02x)",
+				co.ObjectID, co.WindowID, co.X, co.Y, co.Crop != nil, s.Data[off+3])
+			off += 8
+			if c := co.Crop; c != nil {
+				fmt.Printf(" crop x:%d y:%d %dx%d", c.X, c.Y, c.Width, c.Height)
+				off += 8
+			}
+			fmt.Println()
+		}
+	case []pgs.Window:
+		for _, w := range p {
+			fmt.Printf("\twindow:%d x:%d y:%d %dx%d\n", w.ID, w.X, w.Y, w.Width, w.Height)
+		}
+	case *pgs.Palette:
+		fmt.Printf("\tpalette:%d version:%d entries:%d\n", p.ID, p.Version, len(p.Entries))
+		for _, e := range p.Entries {
+			fmt.Printf("\tentry:%d Y:%d Cb:%d Cr:%d A:%d\n", e.ID, e.Y, e.Cb, e.Cr, e.A)
+		}
+	case *pgs.ObjectFragment:
+		fmt.Printf("\tobject:%d version:%d first:%t last:%t (flag 0x%02x)",
+			p.ID, p.Version, p.First, p.Last, s.Data[3])
+		if p.First {
+			fmt.Printf(" length:%d %dx%d", p.DataLength, p.Width, p.Height)
+		}
+		fmt.Printf(" data:%d bytes\n", len(p.Data))
+	}
+}
+
+func checkArgs(args []string, min, max int) {
+	if len(args) < min || len(args) > max {
+		exitUsage()
 	}
 }
 
+func exitUsage() {
+	fmt.Fprintln(os.Stderr, usage)
+	os.Exit(2)
+}
+
+// parseColorSpace parses the optional color space argument.
+func parseColorSpace(arg []string) pgs.ColorSpace {
+	if len(arg) == 0 {
+		return pgs.ColorSpace{}
+	}
+	cs, err := pgs.ParseColorSpace(arg[0])
+	try(err)
+	return cs
+}
+
+func readStream(filename string) []pgs.DisplaySet {
+	f, err := os.Open(filename)
+	try(err)
+	defer f.Close()
+	switch {
+	case isTransportStream(filename):
+		return readAll(filename, ts.NewReader(f, 0))
+	case isMatroska(filename):
+		return retime(filename, readAll(filename, mkv.NewReader(f, 0)))
+	}
+	return readAll(filename, f)
+}
+
+func isTransportStream(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ts", ".m2ts", ".mts":
+		return true
+	}
+	return false
+}
+
+func isMatroska(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".mkv", ".mks":
+		return true
+	}
+	return false
+}
+
+// retime computes the decoding times of a stream read without them,
+// reporting display sets that cannot be scheduled.
+func retime(filename string, stream []pgs.DisplaySet) []pgs.DisplaySet {
+	retimed, errs, err := trans.RecomputeDecodingTimes(stream)
+	try(err)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err.Error())
+	}
+	return retimed
+}
+
+// readAll reads a stream in SUP format from r.
+func readAll(filename string, rd io.Reader) []pgs.DisplaySet {
+	r := pgs.NewReader(rd)
+	if recoverInput {
+		r = pgs.NewRecoveringReader(rd)
+	}
+	stream, err := r.ReadAll()
+	warnings := r.Warnings()
+	if src, ok := rd.(interface{ Warnings() []pgs.Repair }); ok {
+		// Damage skipped in the container
+		warnings = append(src.Warnings(), warnings...)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filename, w)
+	}
+	try(err)
+	return stream
+}
+
+// writeStream writes the stream to the optional filename, or to stdout.
+func writeStream(out []string, stream []pgs.DisplaySet) {
+	f := os.Stdout
+	if len(out) != 0 {
+		var err error
+		f, err = os.Create(out[0])
+		try(err)
+		defer f.Close()
+	}
+	try(pgs.NewWriter(f).WriteAll(stream))
+}
+
+func writePNG(filename string, img image.Image) {
+	f, err := os.Create(filename)
+	try(err)
+	defer f.Close()
+	try(png.Encode(f, img))
+}
+
 func try(err error) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -0,0 +1,161 @@
+// Package mkv reads and writes PGS tracks in Matroska files.
+package mkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Element IDs, including the marker bits
+const (
+	idEBML               = 0x1a45dfa3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42f7
+	idEBMLMaxIDLength    = 0x42f2
+	idEBMLMaxSizeLength  = 0x42f3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+
+	idSegment       = 0x18538067
+	idInfo          = 0x1549a966
+	idTimecodeScale = 0x2ad7b1
+	idDuration      = 0x4489
+	idMuxingApp     = 0x4d80
+	idWritingApp    = 0x5741
+
+	idTracks              = 0x1654ae6b
+	idTrackEntry          = 0xae
+	idTrackNumber         = 0xd7
+	idTrackUID            = 0x73c5
+	idTrackType           = 0x83
+	idFlagLacing          = 0x9c
+	idCodecID             = 0x86
+	idLanguage            = 0x22b59c
+	idContentEncodings    = 0x6d80
+	idContentEncoding     = 0x6240
+	idContentCompression  = 0x5034
+	idContentCompAlgo     = 0x4254
+	idContentCompSettings = 0x4255
+	idContentEncryption   = 0x5035
+
+	idCluster     = 0x1f43b675
+	idTimecode    = 0xe7
+	idSimpleBlock = 0xa3
+	idBlockGroup  = 0xa0
+	idBlock       = 0xa1
+)
+
+const (
+	// CodecPGS is the codec ID of PGS tracks.
+	CodecPGS = "S_HDMV/PGS"
+
+	trackTypeSubtitle = 0x11
+
+	compZlib         = 0
+	compHeaderStrip  = 3
+	defaultTimescale = 1000000 // Nanoseconds per timecode unit
+
+	unknownSize = math.MaxUint64
+)
+
+// readVint reads a variable-length integer and returns it with the
+// marker bit removed if mask is set, and its length.
+func readVint(r io.ByteReader, mask bool) (uint64, int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	n := 1
+	for m := byte(0x80); b&m == 0; m >>= 1 {
+		if m == 1 {
+			return 0, 0, errors.New("invalid variable-length integer")
+		}
+		n++
+	}
+	v := uint64(b)
+	if mask {
+		v &^= 0x80 >> (n - 1)
+	}
+	allOnes := v == 0xff>>n
+	for i := 1; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, 0, err
+		}
+		v = v<<8 | uint64(b)
+		allOnes = allOnes && b == 0xff
+	}
+	if mask && allOnes {
+		v = unknownSize
+	}
+	return v, n, nil
+}
+
+// appendID appends an element ID.
+func appendID(b []byte, id uint32) []byte {
+	switch {
+	case id > 0xffffff:
+		return append(b, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	case id > 0xffff:
+		return append(b, byte(id>>16), byte(id>>8), byte(id))
+	case id > 0xff:
+		return append(b, byte(id>>8), byte(id))
+	}
+	return append(b, byte(id))
+}
+
+// appendSize appends an element size in the shortest length.
+func appendSize(b []byte, size uint64) []byte {
+	n := 1
+	for size >= 1<<(7*n)-1 {
+		n++
+	}
+	v := size | 1<<(7*n)
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+// appendElement appends an element with the data.
+func appendElement(b []byte, id uint32, data []byte) []byte {
+	b = appendID(b, id)
+	b = appendSize(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// appendUint appends an unsigned integer element in the shortest length.
+func appendUint(b []byte, id uint32, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	i := 0
+	for i < 7 && buf[i] == 0 {
+		i++
+	}
+	return appendElement(b, id, buf[i:])
+}
+
+// appendFloat appends a 64-bit float element.
+func appendFloat(b []byte, id uint32, v float64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+	return appendElement(b, id, buf[:])
+}
+
+// parseUint parses the data of an unsigned integer element.
+func parseUint(data []byte) (uint64, error) {
+	if len(data) > 8 {
+		return 0, errors.New("integer element longer than 8 bytes")
+	}
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
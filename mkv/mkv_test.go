@@ -0,0 +1,122 @@
+package mkv
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image/color"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// testDisplaySet returns a display set at t that starts an epoch with
+// a two-line object, or that clears the window when not shown.
+func testDisplaySet(t time.Duration, number uint16, shown bool) pgs.DisplaySet {
+	ds := pgs.DisplaySet{
+		PresentationTime: t,
+		DecodingTime:     t,
+		PresentationComposition: pgs.PresentationComposition{
+			Width:             1920,
+			Height:            1080,
+			FrameRate:         pgs.FrameRate23976,
+			CompositionNumber: number,
+		},
+		Windows: []pgs.Window{{X: 100, Y: 900, Width: 400, Height: 2}},
+	}
+	if shown {
+		ds.CompositionState = pgs.EpochStart
+		ds.CompositionObjects = []pgs.CompositionObject{{ObjectID: 0, X: 100, Y: 900}}
+		ds.Palettes = pgs.Palettes{{Entries: []pgs.PaletteEntry{
+			{ID: 1, NYCbCrA: color.NYCbCrA{YCbCr: color.YCbCr{Y: 235, Cb: 128, Cr: 128}, A: 255}},
+		}}}
+		ds.Objects = []pgs.Object{{ID: 0, Image: pgs.Image{Width: 400, Height: 2,
+			Data: bytes.Repeat([]byte{0, 0x41, 0x90, 0, 0}, 2)}}}
+	}
+	return ds
+}
+
+func readTrack(t *testing.T, data []byte, number uint64) ([]byte, *Reader) {
+	t.Helper()
+	r := NewReader(bytes.NewReader(data), number)
+	sup, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sup, r
+}
+
+func TestMuxRoundTrip(t *testing.T) {
+	stream := []pgs.DisplaySet{
+		testDisplaySet(10*time.Second, 0, true),
+		// Beyond the range of block timecodes in the first cluster
+		testDisplaySet(45*time.Second+5*time.Millisecond, 1, false),
+	}
+	var want bytes.Buffer
+	if err := pgs.NewWriter(&want).WriteAll(stream); err != nil {
+		t.Fatal(err)
+	}
+	var data bytes.Buffer
+	if err := Mux(&data, stream, &MuxOptions{Language: "jpn"}); err != nil {
+		t.Fatal(err)
+	}
+	got, r := readTrack(t, data.Bytes(), 0)
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("read SUP differs:\ngot  %x\nwant %x", got, want.Bytes())
+	}
+	tracks := []Track{{Number: 1, CodecID: CodecPGS, Language: "jpn"}}
+	if !reflect.DeepEqual(r.Tracks(), tracks) {
+		t.Errorf("got tracks %+v, want %+v", r.Tracks(), tracks)
+	}
+}
+
+// TestReadCompressed reads a zlib-compressed PGS track alongside
+// another track, in a segment and cluster of unknown size.
+func TestReadCompressed(t *testing.T) {
+	ds := testDisplaySet(10*time.Second, 0, true)
+	var want bytes.Buffer
+	if err := pgs.NewWriter(&want).Write(&ds); err != nil {
+		t.Fatal(err)
+	}
+	block, err := newBlock(&ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(block)
+	zw.Close()
+
+	var video, pgsTrack []byte
+	video = appendUint(video, idTrackNumber, 1)
+	video = appendElement(video, idCodecID, []byte("V_MPEG4/ISO/AVC"))
+	pgsTrack = appendUint(pgsTrack, idTrackNumber, 2)
+	pgsTrack = appendElement(pgsTrack, idCodecID, []byte(CodecPGS))
+	pgsTrack = appendElement(pgsTrack, idContentEncodings,
+		appendElement(nil, idContentEncoding,
+			appendElement(nil, idContentCompression,
+				appendUint(nil, idContentCompAlgo, compZlib))))
+	var tracks []byte
+	tracks = appendElement(tracks, idTrackEntry, video)
+	tracks = appendElement(tracks, idTrackEntry, pgsTrack)
+
+	var file []byte
+	file = appendElement(file, idEBML, appendElement(nil, idDocType, []byte("matroska")))
+	file = append(file, 0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	file = appendElement(file, idTracks, tracks)
+	file = append(file, 0x1f, 0x43, 0xb6, 0x75, 0xff)
+	file = appendUint(file, idTimecode, 9000)
+	file = appendElement(file, idSimpleBlock, append([]byte{0x81, 0, 0, 0x80}, make([]byte, 1000)...))
+	file = appendElement(file, idBlockGroup,
+		appendElement(nil, idBlock, append([]byte{0x82, 0x03, 0xe8, 0}, z.Bytes()...)))
+
+	got, r := readTrack(t, file, 0)
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("read SUP differs:\ngot  %x\nwant %x", got, want.Bytes())
+	}
+	if r.Number() != 2 {
+		t.Errorf("got track %d, want 2", r.Number())
+	}
+}
@@ -0,0 +1,112 @@
+package mkv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// MuxOptions configures the muxing of a PGS track.
+type MuxOptions struct {
+	Language string // ISO 639-2 code, which is "und" if empty
+}
+
+// Mux writes the stream as a minimal Matroska file with a single PGS
+// track. Each display set is a SimpleBlock of its segments, without
+// segment headers, at its presentation time rounded to milliseconds.
+// Decoding times are not stored. The options may be nil.
+func Mux(w io.Writer, stream []pgs.DisplaySet, opts *MuxOptions) error {
+	var o MuxOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Language == "" {
+		o.Language = "und"
+	}
+
+	var clusters []byte
+	var cluster []byte
+	var clusterTC int64
+	var duration int64
+	for i := range stream {
+		block, err := newBlock(&stream[i])
+		if err != nil {
+			return fmt.Errorf("display set %d: %w", i, err)
+		}
+		tc := int64((stream[i].PresentationTime + time.Millisecond/2) / time.Millisecond)
+		if rel := tc - clusterTC; cluster == nil || rel > math.MaxInt16 || rel < math.MinInt16 {
+			if cluster != nil {
+				clusters = appendElement(clusters, idCluster, cluster)
+			}
+			clusterTC = tc
+			cluster = appendUint(nil, idTimecode, uint64(tc))
+		}
+		rel := tc - clusterTC
+		sb := append([]byte{0x81, byte(rel >> 8), byte(rel), 0x80}, block...) // Track 1, keyframe
+		cluster = appendElement(cluster, idSimpleBlock, sb)
+		duration = tc
+	}
+	if cluster != nil {
+		clusters = appendElement(clusters, idCluster, cluster)
+	}
+
+	var header []byte
+	header = appendUint(header, idEBMLVersion, 1)
+	header = appendUint(header, idEBMLReadVersion, 1)
+	header = appendUint(header, idEBMLMaxIDLength, 4)
+	header = appendUint(header, idEBMLMaxSizeLength, 8)
+	header = appendElement(header, idDocType, []byte("matroska"))
+	header = appendUint(header, idDocTypeVersion, 2)
+	header = appendUint(header, idDocTypeReadVersion, 2)
+
+	var info []byte
+	info = appendUint(info, idTimecodeScale, defaultTimescale)
+	info = appendFloat(info, idDuration, float64(duration))
+	info = appendElement(info, idMuxingApp, []byte("transup"))
+	info = appendElement(info, idWritingApp, []byte("transup"))
+
+	var track []byte
+	track = appendUint(track, idTrackNumber, 1)
+	track = appendUint(track, idTrackUID, 1)
+	track = appendUint(track, idTrackType, trackTypeSubtitle)
+	track = appendUint(track, idFlagLacing, 0)
+	track = appendElement(track, idCodecID, []byte(CodecPGS))
+	track = appendElement(track, idLanguage, []byte(o.Language))
+
+	var segment []byte
+	segment = appendElement(segment, idInfo, info)
+	segment = appendElement(segment, idTracks, appendElement(nil, idTrackEntry, track))
+	segment = append(segment, clusters...)
+
+	var file []byte
+	file = appendElement(file, idEBML, header)
+	file = appendElement(file, idSegment, segment)
+	_, err := w.Write(file)
+	return err
+}
+
+// newBlock creates the payload of a block for the display set, with
+// its segments without segment headers.
+func newBlock(ds *pgs.DisplaySet) ([]byte, error) {
+	var sup bytes.Buffer
+	if err := pgs.NewWriter(&sup).Write(ds); err != nil {
+		return nil, err
+	}
+	var block []byte
+	sr := pgs.NewSegmentReader(&sup)
+	for {
+		s, err := sr.Read()
+		if err == io.EOF {
+			return block, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, byte(s.SegmentType), byte(s.SegmentSize>>8), byte(s.SegmentSize))
+		block = append(block, s.Data...)
+	}
+}
@@ -0,0 +1,316 @@
+package mkv
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Track is a track of a Matroska file.
+type Track struct {
+	Number   uint64
+	CodecID  string
+	Language string // ISO 639-2 code
+
+	compressed   bool
+	compAlgo     uint64
+	compSettings []byte
+	encrypted    bool
+}
+
+// Reader reads a PGS track from a Matroska file as SUP. Blocks store
+// only presentation times, so decoding times equal them; the decoding
+// times can be computed with trans.RecomputeDecodingTimes.
+type Reader struct {
+	r         *bufio.Reader
+	number    uint64 // Number of the PGS track, or 0 until found
+	tracks    []*Track
+	timescale uint64
+	cluster   uint64 // Timecode of the current cluster
+	started   bool   // Whether the EBML header has been read
+	buf       bytes.Buffer
+	sw        *pgs.SegmentWriter
+	err       error
+}
+
+// NewReader creates a reader for the PGS track with the number, or for
+// the first PGS track if number is 0.
+func NewReader(r io.Reader, number uint64) *Reader {
+	mr := &Reader{
+		r:         bufio.NewReader(r),
+		number:    number,
+		timescale: defaultTimescale,
+	}
+	mr.sw = pgs.NewSegmentWriter(&mr.buf)
+	return mr
+}
+
+// Tracks returns the PGS tracks found so far.
+func (r *Reader) Tracks() []Track {
+	var tracks []Track
+	for _, t := range r.tracks {
+		if t.CodecID == CodecPGS {
+			tracks = append(tracks, *t)
+		}
+	}
+	return tracks
+}
+
+// Number returns the number of the PGS track read, or 0 until it is
+// found.
+func (r *Reader) Number() uint64 {
+	return r.number
+}
+
+// Read reads the SUP data of the PGS track.
+func (r *Reader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.readElement()
+		if r.err == io.EOF {
+			if r.number == 0 {
+				r.err = errors.New("no PGS track in Matroska file")
+			} else if r.findTrack(r.number) == nil {
+				r.err = fmt.Errorf("track %d not in Matroska file", r.number)
+			}
+		}
+	}
+	return r.buf.Read(p)
+}
+
+// readElement reads the header of an element and, unless it is a
+// master element to descend into, its data. Master elements are not
+// tracked to their ends, which allows unknown sizes, since the IDs of
+// the elements read are unique within the file.
+func (r *Reader) readElement() error {
+	id, _, err := readVint(r.r, false)
+	if err != nil {
+		return err
+	}
+	if !r.started {
+		if id != idEBML {
+			return errors.New("not a Matroska file: no EBML header")
+		}
+		r.started = true
+	}
+	size, _, err := readVint(r.r, true)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	t := r.track()
+	switch id {
+	case idSegment, idCluster, idTracks, idInfo, idBlockGroup,
+		idContentEncodings, idContentEncoding:
+		return nil
+	case idTrackEntry:
+		r.tracks = append(r.tracks, &Track{Language: "eng"})
+		return nil
+	case idContentCompression:
+		if t != nil {
+			t.compressed = true
+		}
+		return nil
+	case idContentEncryption:
+		if t != nil {
+			t.encrypted = true
+		}
+		return r.discard(size)
+	case idSimpleBlock, idBlock:
+		return r.readBlock(size)
+	case idTimecodeScale, idTimecode, idTrackNumber, idContentCompAlgo,
+		idCodecID, idLanguage, idContentCompSettings:
+	default:
+		return r.discard(size)
+	}
+
+	if size > 0xffff {
+		return fmt.Errorf("element 0x%x too large: %d bytes", id, size)
+	}
+	data, err := r.readData(size)
+	if err != nil {
+		return err
+	}
+	switch id {
+	case idTimecodeScale:
+		r.timescale, err = parseUint(data)
+	case idTimecode:
+		r.cluster, err = parseUint(data)
+	case idTrackNumber:
+		if t != nil {
+			t.Number, err = parseUint(data)
+		}
+	case idContentCompAlgo:
+		if t != nil {
+			t.compAlgo, err = parseUint(data)
+		}
+	case idCodecID:
+		if t != nil {
+			t.CodecID = string(bytes.TrimRight(data, "\x00"))
+		}
+	case idLanguage:
+		if t != nil {
+			t.Language = string(bytes.TrimRight(data, "\x00"))
+		}
+	case idContentCompSettings:
+		if t != nil {
+			t.compSettings = data
+		}
+	}
+	return err
+}
+
+// track returns the track entry being read.
+func (r *Reader) track() *Track {
+	if len(r.tracks) == 0 {
+		return nil
+	}
+	return r.tracks[len(r.tracks)-1]
+}
+
+func (r *Reader) findTrack(number uint64) *Track {
+	for _, t := range r.tracks {
+		if t.Number == number {
+			return t
+		}
+	}
+	return nil
+}
+
+func (r *Reader) readData(size uint64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (r *Reader) discard(size uint64) error {
+	if size == unknownSize {
+		return errors.New("element of unknown size")
+	}
+	for size != 0 {
+		n := size
+		if n > 1<<30 {
+			n = 1 << 30
+		}
+		d, err := r.r.Discard(int(n))
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		size -= uint64(d)
+	}
+	return nil
+}
+
+// readBlock reads a block and, if it is of the PGS track, writes its
+// segments with headers synthesized from its timecode.
+func (r *Reader) readBlock(size uint64) error {
+	if size == unknownSize {
+		return errors.New("block of unknown size")
+	}
+	number, n, err := readVint(r.r, true)
+	if err != nil {
+		return fmt.Errorf("block header: %w", err)
+	}
+	if uint64(n) > size {
+		return errors.New("block header truncated")
+	}
+	if r.number == 0 {
+		for _, t := range r.tracks {
+			if t.CodecID == CodecPGS {
+				r.number = t.Number
+				break
+			}
+		}
+	}
+	if number != r.number || r.number == 0 {
+		return r.discard(size - uint64(n))
+	}
+	t := r.findTrack(number)
+	switch {
+	case t == nil:
+		return fmt.Errorf("block of track %d without track entry", number)
+	case t.CodecID != CodecPGS:
+		return fmt.Errorf("track %d has codec %s, not %s", number, t.CodecID, CodecPGS)
+	case t.encrypted:
+		return fmt.Errorf("track %d is encrypted", number)
+	case size-uint64(n) < 3:
+		return errors.New("block header truncated")
+	case size-uint64(n) > 1<<26:
+		return fmt.Errorf("block too large: %d bytes", size)
+	}
+	data, err := r.readData(size - uint64(n))
+	if err != nil {
+		return err
+	}
+	rel := int16(uint16(data[0])<<8 | uint16(data[1]))
+	if data[2]&0x06 != 0 {
+		return errors.New("laced blocks not supported")
+	}
+	payload := data[3:]
+	tc := int64(r.cluster) + int64(rel)
+	if tc < 0 {
+		return fmt.Errorf("negative block timecode: %d", tc)
+	}
+	ts := pgs.NewTimestamp(time.Duration(tc) * time.Duration(r.timescale))
+
+	if t.compressed {
+		switch t.compAlgo {
+		case compZlib:
+			zr, err := zlib.NewReader(bytes.NewReader(payload))
+			if err != nil {
+				return fmt.Errorf("block at timecode %d: %w", tc, err)
+			}
+			if payload, err = ioutil.ReadAll(zr); err != nil {
+				return fmt.Errorf("block at timecode %d: %w", tc, err)
+			}
+		case compHeaderStrip:
+			payload = append(append([]byte{}, t.compSettings...), payload...)
+		default:
+			return fmt.Errorf("track %d has unsupported compression %d", number, t.compAlgo)
+		}
+	}
+
+	for len(payload) != 0 {
+		if len(payload) < 3 {
+			return fmt.Errorf("block at timecode %d: segment header truncated", tc)
+		}
+		size := int(payload[1])<<8 | int(payload[2])
+		if 3+size > len(payload) {
+			return fmt.Errorf("block at timecode %d: %s segment truncated", tc, pgs.SegmentType(payload[0]))
+		}
+		s := &pgs.Segment{
+			SegmentHeader: pgs.SegmentHeader{
+				MagicNumber:      pgs.MagicNumber,
+				PresentationTime: ts,
+				DecodingTime:     ts,
+				SegmentType:      pgs.SegmentType(payload[0]),
+				SegmentSize:      uint16(size),
+			},
+			Data: payload[3 : 3+size],
+		}
+		if err := r.sw.Write(s); err != nil {
+			return err
+		}
+		payload = payload[3+size:]
+	}
+	return nil
+}
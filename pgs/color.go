@@ -0,0 +1,139 @@
+package pgs
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// ColorMatrix is the matrix used to convert between YCbCr and RGB.
+type ColorMatrix uint8
+
+const (
+	// AutoMatrix selects the matrix from the video height: BT.709 for HD
+	// and BT.601 for SD.
+	AutoMatrix ColorMatrix = iota
+	BT601                  // ITU-R BT.601, used by SD video
+	BT709                  // ITU-R BT.709, used by HD video
+)
+
+// ColorSpace is the color matrix and range of palette entries. The zero
+// value selects the matrix by video height and uses limited range,
+// which matches most Blu-ray discs.
+type ColorSpace struct {
+	Matrix ColorMatrix
+	// FullRange uses the full 0-255 range, rather than the limited range
+	// of 16-235 for luma and 16-240 for chroma.
+	FullRange bool
+}
+
+// JFIF is full range BT.601, the conversion used by color.YCbCr.
+var JFIF = ColorSpace{Matrix: BT601, FullRange: true}
+
+// Resolve selects the matrix for video of the given height, when it is
+// automatic.
+func (cs ColorSpace) Resolve(height uint16) ColorSpace {
+	if cs.Matrix == AutoMatrix {
+		cs.Matrix = BT601
+		if height >= 720 {
+			cs.Matrix = BT709
+		}
+	}
+	return cs
+}
+
+// coefficients returns the luma coefficients Kr and Kb of the matrix.
+// An automatic matrix is treated as BT.601.
+func (cs ColorSpace) coefficients() (kr, kb float64) {
+	if cs.Matrix == BT709 {
+		return 0.2126, 0.0722
+	}
+	return 0.299, 0.114
+}
+
+// scale returns the offset and scale of luma and the scale of chroma.
+func (cs ColorSpace) scale() (yOff, yScale, cScale float64) {
+	if cs.FullRange {
+		return 0, 255, 255
+	}
+	return 16, 219, 224
+}
+
+// RGBA converts a palette entry to non-premultiplied RGBA.
+func (cs ColorSpace) RGBA(e PaletteEntry) color.NRGBA {
+	kr, kb := cs.coefficients()
+	yOff, yScale, cScale := cs.scale()
+	y := (float64(e.Y) - yOff) / yScale
+	cb := (float64(e.Cb) - 128) / cScale
+	cr := (float64(e.Cr) - 128) / cScale
+	r := y + 2*(1-kr)*cr
+	b := y + 2*(1-kb)*cb
+	g := (y - kr*r - kb*b) / (1 - kr - kb)
+	return color.NRGBA{clamp(r * 255), clamp(g * 255), clamp(b * 255), e.A}
+}
+
+// Entry converts a color to a palette entry.
+func (cs ColorSpace) Entry(id uint8, c color.Color) PaletteEntry {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	kr, kb := cs.coefficients()
+	yOff, yScale, cScale := cs.scale()
+	r, g, b := float64(n.R)/255, float64(n.G)/255, float64(n.B)/255
+	y := kr*r + (1-kr-kb)*g + kb*b
+	cb := (b - y) / (2 * (1 - kb))
+	cr := (r - y) / (2 * (1 - kr))
+	return PaletteEntry{
+		ID: id,
+		NYCbCrA: color.NYCbCrA{
+			YCbCr: color.YCbCr{
+				Y:  clamp(y*yScale + yOff),
+				Cb: clamp(cb*cScale + 128),
+				Cr: clamp(cr*cScale + 128),
+			},
+			A: n.A,
+		},
+	}
+}
+
+func clamp(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+// ParseColorSpace parses a color space of the form "auto", "bt601", or
+// "bt709", optionally followed by "-full" for full range.
+func ParseColorSpace(s string) (ColorSpace, error) {
+	var cs ColorSpace
+	if strings.HasSuffix(s, "-full") {
+		cs.FullRange = true
+		s = strings.TrimSuffix(s, "-full")
+	}
+	switch s {
+	case "auto":
+		cs.Matrix = AutoMatrix
+	case "bt601":
+		cs.Matrix = BT601
+	case "bt709":
+		cs.Matrix = BT709
+	default:
+		return cs, fmt.Errorf("unrecognized color space: %q", s)
+	}
+	return cs, nil
+}
+
+func (cs ColorSpace) String() string {
+	var s string
+	switch cs.Matrix {
+	case AutoMatrix:
+		s = "auto"
+	case BT601:
+		s = "bt601"
+	case BT709:
+		s = "bt709"
+	default:
+		s = fmt.Sprintf("matrix(%d)", cs.Matrix)
+	}
+	if cs.FullRange {
+		s += "-full"
+	}
+	return s
+}
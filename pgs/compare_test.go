@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"image/color"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -26,13 +28,145 @@ func TestTwoWay(t *testing.T) {
 	}
 }
 
+func TestMultipleDefinitions(t *testing.T) {
+	large := make([]byte, 3*maxNextFragment)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	ds := &DisplaySet{
+		PresentationTime: Timestamp(12345).Duration(),
+		DecodingTime:     Timestamp(12001).Duration(),
+		PresentationComposition: PresentationComposition{
+			Width:            1920,
+			Height:           1080,
+			FrameRate:        0x10,
+			CompositionState: EpochStart,
+			CompositionObjects: []CompositionObject{
+				{ObjectID: 0, WindowID: 0, X: 10, Y: 20},
+				{ObjectID: 1, WindowID: 1, X: 10, Y: 900},
+			},
+		},
+		Windows: []Window{
+			{ID: 0, X: 10, Y: 20, Width: 100, Height: 50},
+			{ID: 1, X: 10, Y: 900, Width: 100, Height: 50},
+		},
+		Palettes: Palettes{
+			{ID: 0, Entries: []PaletteEntry{{ID: 1, NYCbCrA: color.NYCbCrA{YCbCr: color.YCbCr{Y: 235, Cb: 128, Cr: 128}, A: 255}}}},
+			{ID: 1, Entries: []PaletteEntry{{ID: 1, NYCbCrA: color.NYCbCrA{YCbCr: color.YCbCr{Y: 16, Cb: 128, Cr: 128}, A: 255}}}},
+		},
+		Objects: []Object{
+			{ID: 0, Image: Image{Width: 100, Height: 50, Data: []byte{1, 2, 3}}},
+			{ID: 1, Image: Image{Width: 100, Height: 50, Data: large}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).Write(ds); err != nil {
+		t.Fatal(err)
+	}
+	raw := append([]byte(nil), buf.Bytes()...)
+	ds2, err := NewReader(&buf).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ds, ds2) {
+		t.Errorf("read %+v, want %+v", ds2, ds)
+	}
+	if err := NewWriter(&buf).Write(ds2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, buf.Bytes()) {
+		t.Error("serialized display set differs")
+	}
+}
+
+func TestSegmentVerbatim(t *testing.T) {
+	var buf bytes.Buffer
+	ds := &DisplaySet{PresentationComposition: PresentationComposition{CompositionState: EpochStart}}
+	if err := NewWriter(&buf).Write(ds); err != nil {
+		t.Fatal(err)
+	}
+	// Trailing byte in the PCS, declared in the header
+	raw := buf.Bytes()
+	raw = append(raw[:12:12], append([]byte{12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff}, raw[24:]...)...)
+
+	if _, err := NewReader(bytes.NewReader(raw)).Read(); err == nil {
+		t.Error("damaged display set read without error")
+	}
+	r := NewSegmentReader(bytes.NewReader(raw))
+	var out bytes.Buffer
+	w := NewSegmentWriter(&out)
+	var offsets []int64
+	for {
+		s, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, s.Offset)
+		if err := w.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(out.Bytes(), raw) {
+		t.Errorf("segments written as % x, want % x", out.Bytes(), raw)
+	}
+	if !reflect.DeepEqual(offsets, []int64{0, 25}) {
+		t.Errorf("segment offsets %v, want [0 25]", offsets)
+	}
+}
+
+// TestTwoWayGenerated checks, without test data, that each display set
+// is read without reading ahead into the next and is written back
+// identically.
+func TestTwoWayGenerated(t *testing.T) {
+	entry := PaletteEntry{ID: 1, NYCbCrA: color.NYCbCrA{YCbCr: color.YCbCr{Y: 235, Cb: 128, Cr: 128}, A: 255}}
+	pc := PresentationComposition{Width: 1920, Height: 1080, FrameRate: FrameRate23976}
+	w := Window{X: 100, Y: 900, Width: 100, Height: 50}
+	stream := make([]DisplaySet, 3)
+	for i := range stream {
+		stream[i].PresentationTime = Timestamp(90000 * (i + 1)).Duration()
+		stream[i].DecodingTime = stream[i].PresentationTime - Timestamp(900).Duration()
+		stream[i].PresentationComposition = pc
+		stream[i].CompositionNumber = uint16(i)
+	}
+	stream[0].CompositionState = EpochStart
+	stream[0].CompositionObjects = []CompositionObject{{X: 100, Y: 900}}
+	stream[0].Windows = []Window{w}
+	stream[0].Palettes = Palettes{{Entries: []PaletteEntry{entry}}}
+	stream[0].Objects = []Object{{Image: Image{Width: 100, Height: 50, Data: bytes.Repeat([]byte{1}, 5000)}}}
+	entry.A = 0x80
+	stream[1].PaletteUpdate = true
+	stream[1].CompositionObjects = stream[0].CompositionObjects
+	stream[1].Palettes = Palettes{{Version: 1, Entries: []PaletteEntry{entry}}}
+	stream[2].Windows = []Window{w}
+
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).WriteAll(stream); err != nil {
+		t.Fatal(err)
+	}
+	eq, err := testTwoWay("generated", &buf, os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	} else if !eq {
+		t.Error("generated stream differs")
+	}
+}
+
 func testFileTwoWay(filename string, log io.Writer) (bool, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return false, err
 	}
 	defer f.Close()
-	c := NewComparer(f)
+	return testTwoWay(filename, f, log)
+}
+
+// testTwoWay reads each display set and writes it back, comparing the
+// bytes read for it to the bytes written.
+func testTwoWay(filename string, rd io.Reader, log io.Writer) (bool, error) {
+	c := NewComparer(rd)
 	r := NewReader(c)
 	w := NewWriter(c)
 
@@ -0,0 +1,64 @@
+package pgs
+
+import "time"
+
+// Rates of the decoder model in the Blu-ray specification, in bytes per
+// second, with one byte per pixel.
+const (
+	pixelDecodingRate = 128e6 / 8 // Rd: decoding objects into the object buffer
+	pixelTransferRate = 256e6 / 8 // Rc: clearing and drawing the graphics plane
+)
+
+// DecodeDuration returns the time the decoder model needs between
+// decoding and presenting a display set, given the epoch state before
+// it. It is the sum of the time to initialize the graphics plane,
+// which clears the whole plane for an Epoch Start or else the windows,
+// the time to decode the objects defined in the display set, and the
+// time to draw the windows of the composition.
+func DecodeDuration(ds *DisplaySet, e *Epoch) time.Duration {
+	windows := make(map[uint8]Window)
+	if ds.CompositionState != EpochStart {
+		for id, w := range e.Windows {
+			windows[id] = w
+		}
+	}
+	for _, w := range ds.Windows {
+		windows[w.ID] = w
+	}
+
+	var ticks int64
+	if ds.CompositionState == EpochStart {
+		ticks += transferTicks(int64(ds.Width) * int64(ds.Height))
+	} else {
+		for _, w := range windows {
+			ticks += transferTicks(int64(w.Width) * int64(w.Height))
+		}
+	}
+	for _, obj := range ds.Objects {
+		ticks += decodeTicks(int64(obj.Width) * int64(obj.Height))
+	}
+	drawn := make(map[uint8]bool)
+	for _, co := range ds.CompositionObjects {
+		if w, ok := windows[co.WindowID]; ok && !drawn[co.WindowID] {
+			ticks += transferTicks(int64(w.Width) * int64(w.Height))
+			drawn[co.WindowID] = true
+		}
+	}
+	return Timestamp(ticks).Duration()
+}
+
+// decodeTicks returns the 90 kHz ticks to decode the pixels of an
+// object.
+func decodeTicks(pixels int64) int64 {
+	return ceilDiv(90000*pixels, pixelDecodingRate)
+}
+
+// transferTicks returns the 90 kHz ticks to clear or draw the pixels of
+// an area of the graphics plane.
+func transferTicks(pixels int64) int64 {
+	return ceilDiv(90000*pixels, pixelTransferRate)
+}
+
+func ceilDiv(n, d int64) int64 {
+	return (n + d - 1) / d
+}
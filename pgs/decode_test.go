@@ -0,0 +1,52 @@
+package pgs
+
+import "testing"
+
+func TestDecodeDuration(t *testing.T) {
+	w := Window{ID: 0, X: 100, Y: 900, Width: 100, Height: 50}
+	start := &DisplaySet{
+		PresentationComposition: PresentationComposition{
+			Width:              1920,
+			Height:             1080,
+			CompositionState:   EpochStart,
+			CompositionObjects: []CompositionObject{{ObjectID: 0, WindowID: 0, X: 100, Y: 900}},
+		},
+		Windows:  []Window{w},
+		Palettes: Palettes{{ID: 0}},
+		Objects:  []Object{{ID: 0, Image: Image{Width: 100, Height: 50}}},
+	}
+	fade := &DisplaySet{
+		PresentationComposition: PresentationComposition{
+			Width:              1920,
+			Height:             1080,
+			PaletteUpdate:      true,
+			CompositionObjects: start.CompositionObjects,
+		},
+		Palettes: Palettes{{ID: 0, Version: 1}},
+	}
+	clear := &DisplaySet{
+		PresentationComposition: PresentationComposition{Width: 1920, Height: 1080},
+		Windows:                 []Window{w},
+	}
+
+	e := NewEpoch()
+	// Clearing the plane takes 90000*1920*1080/32e6 = 5832 ticks, decoding
+	// the object 90000*5000/16e6 = 28.125 ticks, and drawing and clearing
+	// the window 90000*5000/32e6 = 14.0625 ticks, each rounded up.
+	for _, tt := range []struct {
+		name  string
+		ds    *DisplaySet
+		ticks Timestamp
+	}{
+		{"Epoch Start", start, 5832 + 29 + 15},
+		{"palette update", fade, 15 + 15},
+		{"clear", clear, 15},
+	} {
+		if d, want := DecodeDuration(tt.ds, e), tt.ticks.Duration(); d != want {
+			t.Errorf("%s: got %s, want %s", tt.name, d, want)
+		}
+		if err := e.Apply(tt.ds); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package pgs
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"sort"
+	"time"
+)
+
+// Epoch is the state of a decoder within an epoch. Display sets only
+// carry the segments that change from the preceding composition, so
+// the windows, palettes, and objects defined since the Epoch Start are
+// needed to know what is on screen.
+type Epoch struct {
+	Windows     map[uint8]Window
+	Palettes    map[uint8]Palette
+	Objects     map[uint16]Object
+	Composition PresentationComposition // Current composition
+}
+
+// NewEpoch creates an empty epoch state.
+func NewEpoch() *Epoch {
+	return &Epoch{
+		Windows:  make(map[uint8]Window),
+		Palettes: make(map[uint8]Palette),
+		Objects:  make(map[uint16]Object),
+	}
+}
+
+// Apply updates the state with the definitions and composition of a
+// display set. An Epoch Start clears all previous definitions. A new
+// version of a palette only updates the entries it defines and the
+// others keep their colors.
+func (e *Epoch) Apply(ds *DisplaySet) error {
+	if ds.CompositionState == EpochStart {
+		*e = *NewEpoch()
+	}
+	for _, w := range ds.Windows {
+		e.Windows[w.ID] = w
+	}
+	for _, p := range ds.Palettes {
+		if prev, ok := e.Palettes[p.ID]; ok {
+			p = updatePalette(&prev, &p)
+		}
+		e.Palettes[p.ID] = p
+	}
+	for _, obj := range ds.Objects {
+		e.Objects[obj.ID] = obj
+	}
+	pc := ds.PresentationComposition
+	if pc.PaletteUpdate && len(pc.CompositionObjects) == 0 {
+		// A palette only update keeps the composed objects
+		pc.CompositionObjects = e.Composition.CompositionObjects
+	}
+	e.Composition = pc
+	return e.check()
+}
+
+// updatePalette returns the new version of a palette with its entries
+// replacing those with the same IDs in the previous version, ordered by
+// ID.
+func updatePalette(prev, p *Palette) Palette {
+	var entries [256]PaletteEntry
+	var defined [256]bool
+	for _, es := range [][]PaletteEntry{prev.Entries, p.Entries} {
+		for _, e := range es {
+			entries[e.ID], defined[e.ID] = e, true
+		}
+	}
+	updated := Palette{ID: p.ID, Version: p.Version}
+	for id, e := range entries {
+		if defined[id] {
+			updated.Entries = append(updated.Entries, e)
+		}
+	}
+	return updated
+}
+
+// check verifies that the definitions referenced by the current
+// composition exist.
+func (e *Epoch) check() error {
+	if len(e.Composition.CompositionObjects) == 0 {
+		return nil
+	}
+	if _, ok := e.Palettes[e.Composition.PaletteID]; !ok {
+		return fmt.Errorf("palette %d not defined", e.Composition.PaletteID)
+	}
+	for _, co := range e.Composition.CompositionObjects {
+		if _, ok := e.Objects[co.ObjectID]; !ok {
+			return fmt.Errorf("object %d not defined", co.ObjectID)
+		}
+		if _, ok := e.Windows[co.WindowID]; !ok {
+			return fmt.Errorf("window %d not defined", co.WindowID)
+		}
+	}
+	return nil
+}
+
+// Render composites the current composition into a full screen image.
+// Each composition object is cropped, placed at its position, and
+// clipped to its window. An automatic color matrix is selected by the
+// video height.
+func (e *Epoch) Render(cs ColorSpace) (*image.RGBA, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, int(e.Composition.Width), int(e.Composition.Height)))
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	if len(e.Composition.CompositionObjects) == 0 {
+		return dst, nil
+	}
+	p := e.Palettes[e.Composition.PaletteID]
+	cs = cs.Resolve(e.Composition.Height)
+	for _, co := range e.Composition.CompositionObjects {
+		obj := e.Objects[co.ObjectID]
+		img, err := obj.Convert(&p, cs)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %w", co.ObjectID, err)
+		}
+		src := img.Bounds()
+		if co.Crop != nil {
+			src = image.Rect(int(co.Crop.X), int(co.Crop.Y),
+				int(co.Crop.X)+int(co.Crop.Width), int(co.Crop.Y)+int(co.Crop.Height)).Intersect(src)
+		}
+		pos := image.Pt(int(co.X), int(co.Y))
+		r := src.Sub(src.Min).Add(pos).Intersect(e.Windows[co.WindowID].Rect())
+		draw.Draw(dst, r, img, src.Min.Add(r.Min.Sub(pos)), draw.Over)
+	}
+	return dst, nil
+}
+
+// Rect returns the area of the screen covered by the window.
+func (w Window) Rect() image.Rectangle {
+	return image.Rect(int(w.X), int(w.Y), int(w.X)+int(w.Width), int(w.Y)+int(w.Height))
+}
+
+// Compositor renders the frame on screen at any time in a stream.
+type Compositor struct {
+	stream []DisplaySet
+}
+
+// NewCompositor creates a compositor for a stream ordered by
+// presentation time.
+func NewCompositor(stream []DisplaySet) *Compositor {
+	return &Compositor{stream}
+}
+
+// Epoch returns the epoch state after presenting all display sets up
+// to time t, or nil if no display set is presented by then.
+func (c *Compositor) Epoch(t time.Duration) (*Epoch, error) {
+	n := sort.Search(len(c.stream), func(i int) bool {
+		return c.stream[i].PresentationTime > t
+	})
+	if n == 0 {
+		return nil, nil
+	}
+	start := n - 1
+	for start > 0 && c.stream[start].CompositionState != EpochStart {
+		start--
+	}
+	e := NewEpoch()
+	for i := start; i < n; i++ {
+		if err := e.Apply(&c.stream[i]); err != nil {
+			return nil, fmt.Errorf("display set %d/%d: %w", i, len(c.stream), err)
+		}
+	}
+	return e, nil
+}
+
+// Render composites the full screen frame on screen at time t.
+func (c *Compositor) Render(t time.Duration, cs ColorSpace) (image.Image, error) {
+	if len(c.stream) == 0 {
+		return nil, errors.New("empty stream")
+	}
+	e, err := c.Epoch(t)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		pc := &c.stream[0].PresentationComposition
+		return image.NewRGBA(image.Rect(0, 0, int(pc.Width), int(pc.Height))), nil
+	}
+	return e.Render(cs)
+}
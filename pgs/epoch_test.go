@@ -0,0 +1,119 @@
+package pgs
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestCompositor(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	p := color.Palette{color.Transparent, red}
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), p)
+	for i := range img.Pix {
+		img.Pix[i] = 1
+	}
+	obj, err := NewObject(0, 0, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pal, err := NewPalette(0, 0, p, ColorSpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	faded := *pal
+	faded.Version = 1
+	faded.Entries = []PaletteEntry{{ID: 1}}
+
+	pc := PresentationComposition{Width: 16, Height: 16}
+	stream := []DisplaySet{
+		{PresentationTime: 1 * time.Second},
+		{PresentationTime: 2 * time.Second},
+		{PresentationTime: 3 * time.Second},
+		{PresentationTime: 4 * time.Second},
+	}
+	for i := range stream {
+		stream[i].PresentationComposition = pc
+	}
+	// Object cropped to 2x3 at (1, 1), clipped by the window to 2x2
+	stream[0].CompositionState = EpochStart
+	stream[0].CompositionObjects = []CompositionObject{{X: 5, Y: 6, Crop: &CompositionObjectCrop{X: 1, Y: 1, Width: 2, Height: 3}}}
+	stream[0].Windows = []Window{{X: 0, Y: 0, Width: 10, Height: 8}}
+	stream[0].Palettes = Palettes{*pal}
+	stream[0].Objects = []Object{*obj}
+	// Palette only update
+	stream[1].PaletteUpdate = true
+	stream[1].Palettes = Palettes{faded}
+	// Redraw with the first palette version, then clear
+	stream[2].CompositionObjects = []CompositionObject{{X: 0, Y: 0}}
+	stream[2].Palettes = Palettes{*pal}
+
+	c := NewCompositor(stream)
+	tests := []struct {
+		t    time.Duration
+		rect image.Rectangle
+	}{
+		{0, image.Rectangle{}},
+		{1500 * time.Millisecond, image.Rect(5, 6, 7, 8)},
+		{2 * time.Second, image.Rectangle{}},
+		{3 * time.Second, image.Rect(0, 0, 4, 4)},
+		{5 * time.Second, image.Rectangle{}},
+	}
+	for _, tt := range tests {
+		frame, err := c.Render(tt.t, ColorSpace{})
+		if err != nil {
+			t.Fatalf("render %s: %v", tt.t, err)
+		}
+		b := frame.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				_, _, _, a := frame.At(x, y).RGBA()
+				if visible := a != 0; visible != image.Pt(x, y).In(tt.rect) {
+					t.Errorf("render %s: pixel (%d, %d) visible=%t", tt.t, x, y, visible)
+				}
+			}
+		}
+	}
+}
+
+func TestApplyPaletteUpdate(t *testing.T) {
+	p := color.Palette{color.Transparent, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}}
+	img := image.NewPaletted(image.Rect(0, 0, 2, 1), p)
+	img.Pix[0], img.Pix[1] = 1, 2
+	obj, err := NewObject(0, 0, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pal, err := NewPalette(0, 0, p, ColorSpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := PresentationComposition{Width: 4, Height: 4, CompositionObjects: []CompositionObject{{}}}
+	e := NewEpoch()
+	start := DisplaySet{PresentationComposition: pc, Windows: []Window{{Width: 2, Height: 1}},
+		Palettes: Palettes{*pal}, Objects: []Object{*obj}}
+	start.CompositionState = EpochStart
+	if err := e.Apply(&start); err != nil {
+		t.Fatal(err)
+	}
+	// Fade only the second color
+	update := DisplaySet{PresentationComposition: pc, Palettes: Palettes{{Version: 1,
+		Entries: []PaletteEntry{{ID: 2, NYCbCrA: color.NYCbCrA{YCbCr: pal.Entries[2].YCbCr, A: 0x80}}}}}}
+	update.PaletteUpdate = true
+	if err := e.Apply(&update); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Palettes[0]; got.Version != 1 || len(got.Entries) != 3 {
+		t.Fatalf("got palette version %d with %d entries, want version 1 with 3", got.Version, len(got.Entries))
+	}
+	frame, err := e.Render(ColorSpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, alpha := range []uint8{0xff, 0x80} {
+		if a := frame.RGBAAt(x, 0).A; a != alpha {
+			t.Errorf("pixel %d has alpha 0x%x, want 0x%x", x, a, alpha)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package pgs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+)
+
+// Event is a period during which the screen shows the same images in
+// the same colors.
+type Event struct {
+	Start, End time.Duration // End is zero if shown until the end of the stream
+	Images     []EventImage
+	Palette    []PaletteEntry // Colors of the images, by entry ID
+}
+
+// EventImage is an image positioned on screen. The color index of each
+// pixel is its palette entry ID and entries not defined by the palette
+// are transparent.
+type EventImage struct {
+	X, Y  int // Offset from the top left pixel of the screen
+	Image *image.Paletted
+}
+
+// Rect returns the area of the screen covered by the image.
+func (img *EventImage) Rect() image.Rectangle {
+	b := img.Image.Bounds()
+	return b.Sub(b.Min).Add(image.Pt(img.X, img.Y))
+}
+
+// Events returns an event for each change of what is on screen after a
+// display set, whether by new objects, new positions, or new palettes,
+// so that Acquisition Points and redefinitions that change nothing
+// visible are merged into the events they continue. Each composition
+// object is cropped and clipped to its window. The colors of the
+// images are converted to RGB in the color space.
+func Events(stream []DisplaySet, cs ColorSpace) ([]Event, error) {
+	var events []Event
+	var cur *Event
+	e := NewEpoch()
+	for i := range stream {
+		ds := &stream[i]
+		if err := e.Apply(ds); err != nil {
+			return nil, fmt.Errorf("display set %d/%d: %w", i, len(stream), err)
+		}
+		ev, err := e.event(cs)
+		if err != nil {
+			return nil, fmt.Errorf("display set %d/%d: %w", i, len(stream), err)
+		}
+		if cur != nil && ev != nil && sameEvent(cur, ev) {
+			continue
+		}
+		if cur != nil {
+			events[len(events)-1].End = ds.PresentationTime
+		}
+		cur = ev
+		if ev != nil {
+			ev.Start = ds.PresentationTime
+			events = append(events, *ev)
+		}
+	}
+	return events, nil
+}
+
+// event returns the images of the current composition and its palette,
+// or nil if nothing is shown.
+func (e *Epoch) event(cs ColorSpace) (*Event, error) {
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	if len(e.Composition.CompositionObjects) == 0 {
+		return nil, nil
+	}
+	p := e.Palettes[e.Composition.PaletteID]
+	cs = cs.Resolve(e.Composition.Height)
+	ev := &Event{Palette: p.Entries}
+	for _, co := range e.Composition.CompositionObjects {
+		obj := e.Objects[co.ObjectID]
+		img, err := obj.Convert(&p, cs)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %w", co.ObjectID, err)
+		}
+		src := img.Bounds()
+		if co.Crop != nil {
+			src = image.Rect(int(co.Crop.X), int(co.Crop.Y),
+				int(co.Crop.X)+int(co.Crop.Width), int(co.Crop.Y)+int(co.Crop.Height)).Intersect(src)
+		}
+		pos := image.Pt(int(co.X), int(co.Y))
+		r := src.Sub(src.Min).Add(pos).Intersect(e.Windows[co.WindowID].Rect())
+		if r.Empty() {
+			continue
+		}
+		sub := image.NewPaletted(image.Rectangle{Max: r.Size()}, img.Palette)
+		off := src.Min.Add(r.Min.Sub(pos))
+		for y := 0; y < r.Dy(); y++ {
+			i := img.PixOffset(off.X, off.Y+y)
+			copy(sub.Pix[y*sub.Stride:], img.Pix[i:i+r.Dx()])
+		}
+		ev.Images = append(ev.Images, EventImage{X: r.Min.X, Y: r.Min.Y, Image: sub})
+	}
+	if len(ev.Images) == 0 {
+		return nil, nil
+	}
+	return ev, nil
+}
+
+// sameEvent reports whether two events show the same images in the
+// same colors.
+func sameEvent(a, b *Event) bool {
+	return sameImages(a.Images, b.Images) && samePalette(a.Palette, b.Palette)
+}
+
+func sameImages(a, b []EventImage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		ai, bi := a[i].Image, b[i].Image
+		if a[i].Rect() != b[i].Rect() {
+			return false
+		}
+		w := ai.Rect.Dx()
+		for y := 0; y < ai.Rect.Dy(); y++ {
+			j := ai.PixOffset(ai.Rect.Min.X, ai.Rect.Min.Y+y)
+			k := bi.PixOffset(bi.Rect.Min.X, bi.Rect.Min.Y+y)
+			if !bytes.Equal(ai.Pix[j:j+w], bi.Pix[k:k+w]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func samePalette(a, b []PaletteEntry) bool {
+	var ca, cb [256]color.NYCbCrA
+	var da, db [256]bool
+	for _, e := range a {
+		ca[e.ID], da[e.ID] = e.NYCbCrA, true
+	}
+	for _, e := range b {
+		cb[e.ID], db[e.ID] = e.NYCbCrA, true
+	}
+	return ca == cb && da == db
+}
+
+// CompileEvents compiles events into a stream with the fewest display
+// sets: an Epoch Start for new images, a palette update for new colors
+// of the images shown, a Normal composition to show the images of the
+// epoch again after a gap, and a Normal composition without objects to
+// clear the screen. The screen size and frame rate are those of pc. Up
+// to two images that do not overlap each have a window and object,
+// otherwise the images are combined into one. Decoding times are set by
+// the decoder model, but do not start before the preceding
+// presentation.
+func CompileEvents(events []Event, pc PresentationComposition) ([]DisplaySet, error) {
+	screen := image.Rect(0, 0, int(pc.Width), int(pc.Height))
+	pc.CompositionState = Normal
+	pc.CompositionObjects = nil
+	pc.PaletteUpdate = false
+	pc.PaletteID = 0
+
+	var stream []DisplaySet
+	e := NewEpoch()
+	var cur *Event // Event of the images of the epoch
+	var paletteVersion uint8
+	var windows []Window
+	var objects []CompositionObject
+	emit := func(ds *DisplaySet) error {
+		ds.CompositionNumber = uint16(len(stream))
+		ds.DecodingTime = ds.PresentationTime - DecodeDuration(ds, e)
+		if n := len(stream); n != 0 && ds.DecodingTime < stream[n-1].PresentationTime {
+			ds.DecodingTime = stream[n-1].PresentationTime
+		}
+		if ds.DecodingTime < 0 {
+			ds.DecodingTime = 0
+		}
+		if err := e.Apply(ds); err != nil {
+			return err
+		}
+		stream = append(stream, *ds)
+		return nil
+	}
+
+	for i := range events {
+		ev := &events[i]
+		if ev.End != 0 && ev.End <= ev.Start {
+			return nil, fmt.Errorf("event %d: ends at %s before starting at %s", i+1, ev.End, ev.Start)
+		}
+		if i != 0 && (ev.Start < events[i-1].End || ev.Start <= events[i-1].Start) {
+			return nil, fmt.Errorf("event %d: starts at %s before event %d ends", i+1, ev.Start, i)
+		}
+		if len(ev.Images) == 0 {
+			return nil, fmt.Errorf("event %d: no images", i+1)
+		}
+		for _, img := range ev.Images {
+			if r := img.Rect(); r.Empty() {
+				return nil, fmt.Errorf("event %d: empty image", i+1)
+			} else if !r.In(screen) {
+				return nil, fmt.Errorf("event %d: image at %v outside of %dx%d screen",
+					i+1, r, pc.Width, pc.Height)
+			}
+		}
+		gap := i != 0 && events[i-1].End != 0 && events[i-1].End < ev.Start
+		if gap {
+			clear := DisplaySet{PresentationTime: events[i-1].End, PresentationComposition: pc, Windows: windows}
+			if err := emit(&clear); err != nil {
+				return nil, fmt.Errorf("event %d: %w", i, err)
+			}
+		}
+		contiguous := i != 0 && !gap
+
+		ds := DisplaySet{PresentationTime: ev.Start, PresentationComposition: pc}
+		switch {
+		case cur != nil && sameImages(cur.Images, ev.Images):
+			if !samePalette(cur.Palette, ev.Palette) {
+				paletteVersion++
+				entries := updateEntries(e.Palettes[0].Entries, ev.Palette)
+				ds.Palettes = Palettes{{Version: paletteVersion, Entries: entries}}
+				ds.PaletteUpdate = contiguous
+			} else if contiguous {
+				continue // Nothing changes on screen
+			}
+			ds.CompositionObjects = objects
+		default:
+			images, err := combineImages(ev)
+			if err != nil {
+				return nil, fmt.Errorf("event %d: %w", i+1, err)
+			}
+			windows, objects = nil, nil
+			for j, img := range images {
+				obj, err := NewObject(uint16(j), 0, img.Image, nil)
+				if err != nil {
+					return nil, fmt.Errorf("event %d: %w", i+1, err)
+				}
+				r := img.Rect()
+				w := Window{ID: uint8(j), X: uint16(r.Min.X), Y: uint16(r.Min.Y),
+					Width: uint16(r.Dx()), Height: uint16(r.Dy())}
+				windows = append(windows, w)
+				objects = append(objects, CompositionObject{ObjectID: uint16(j), WindowID: uint8(j), X: w.X, Y: w.Y})
+				ds.Objects = append(ds.Objects, *obj)
+			}
+			paletteVersion = 0
+			ds.CompositionState = EpochStart
+			ds.Windows = windows
+			ds.Palettes = Palettes{{Entries: ev.Palette}}
+			ds.CompositionObjects = objects
+		}
+		if err := emit(&ds); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i+1, err)
+		}
+		cur = ev
+	}
+	if n := len(events); n != 0 && events[n-1].End != 0 {
+		clear := DisplaySet{PresentationTime: events[n-1].End, PresentationComposition: pc, Windows: windows}
+		if err := emit(&clear); err != nil {
+			return nil, fmt.Errorf("event %d: %w", n, err)
+		}
+	}
+	return stream, nil
+}
+
+// updateEntries returns the entries with those of prev that they do not
+// define made transparent, as a new palette version only updates the
+// entries it defines.
+func updateEntries(prev, entries []PaletteEntry) []PaletteEntry {
+	var defined [256]bool
+	for _, e := range entries {
+		defined[e.ID] = true
+	}
+	updated := append([]PaletteEntry{}, entries...)
+	for _, e := range prev {
+		if !defined[e.ID] {
+			updated = append(updated, PaletteEntry{ID: e.ID,
+				NYCbCrA: color.NYCbCrA{YCbCr: color.YCbCr{Y: 16, Cb: 128, Cr: 128}}})
+		}
+	}
+	return updated
+}
+
+// combineImages returns the images of the event, or a single image
+// covering them all when there are more than two or they overlap, with
+// the space between them filled with an entry ID not defined by the
+// palette.
+func combineImages(ev *Event) ([]EventImage, error) {
+	images := ev.Images
+	if len(images) == 1 || len(images) == 2 && !images[0].Rect().Overlaps(images[1].Rect()) {
+		return images, nil
+	}
+	var defined, visible [256]bool
+	for _, e := range ev.Palette {
+		defined[e.ID] = true
+		visible[e.ID] = e.A != 0
+	}
+	fill := -1
+	for id := 255; id >= 0; id-- {
+		if !defined[id] {
+			fill = id
+			break
+		}
+	}
+	if fill == -1 {
+		return nil, errors.New("no transparent palette entry to combine images")
+	}
+	var r image.Rectangle
+	for _, img := range images {
+		r = r.Union(img.Rect())
+	}
+	dst := image.NewPaletted(image.Rectangle{Max: r.Size()}, images[0].Image.Palette)
+	for i := range dst.Pix {
+		dst.Pix[i] = uint8(fill)
+	}
+	for _, img := range images {
+		src := img.Image
+		off := img.Rect().Min.Sub(r.Min)
+		for y := 0; y < src.Rect.Dy(); y++ {
+			for x := 0; x < src.Rect.Dx(); x++ {
+				c := src.ColorIndexAt(src.Rect.Min.X+x, src.Rect.Min.Y+y)
+				if visible[c] {
+					dst.SetColorIndex(off.X+x, off.Y+y, c)
+				}
+			}
+		}
+	}
+	return []EventImage{{X: r.Min.X, Y: r.Min.Y, Image: dst}}, nil
+}
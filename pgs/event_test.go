@@ -0,0 +1,115 @@
+package pgs_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+	"github.com/andrewarchi/transup/pgs/pgstest"
+)
+
+func TestEvents(t *testing.T) {
+	pix := []uint8{1, 2, 1, 2, 1, 2, 1, 2}
+	obj0 := pgstest.NewObject(t, 0, 0, 4, pix)
+	obj1 := pgstest.NewObject(t, 1, 0, 4, pix)
+	pal, faded := pgstest.NewPalettes(t, color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255})
+
+	pc := pgs.PresentationComposition{Width: 32, Height: 32}
+	stream := make([]pgs.DisplaySet, 5)
+	for i := range stream {
+		stream[i].PresentationTime = time.Duration(i+1) * time.Second
+		stream[i].CompositionNumber = uint16(i)
+		stream[i].PresentationComposition = pc
+	}
+	windows := []pgs.Window{{ID: 0, X: 0, Y: 0, Width: 8, Height: 8}, {ID: 1, X: 10, Y: 20, Width: 8, Height: 8}}
+	both := []pgs.CompositionObject{{ObjectID: 0, WindowID: 0, X: 1, Y: 1}, {ObjectID: 1, WindowID: 1, X: 10, Y: 20}}
+	// Two objects in two windows
+	stream[0].CompositionState = pgs.EpochStart
+	stream[0].Windows = windows
+	stream[0].Palettes = pgs.Palettes{pal}
+	stream[0].Objects = []pgs.Object{obj0, obj1}
+	stream[0].CompositionObjects = both
+	// Palette only update to fade the first color
+	stream[1].PaletteUpdate = true
+	stream[1].Palettes = pgs.Palettes{faded}
+	stream[1].CompositionObjects = both
+	// Acquisition Point that changes nothing
+	stream[2].CompositionState = pgs.AcquisitionPoint
+	stream[2].Windows = windows
+	stream[2].Palettes = pgs.Palettes{faded}
+	stream[2].Objects = []pgs.Object{obj0, obj1}
+	stream[2].CompositionObjects = both
+	// One object remains, cropped to a column, then clear
+	stream[3].CompositionObjects = []pgs.CompositionObject{{ObjectID: 1, WindowID: 1, X: 10, Y: 20,
+		Crop: &pgs.CompositionObjectCrop{X: 1, Y: 0, Width: 1, Height: 2}}}
+
+	events, err := pgs.Events(stream, pgs.ColorSpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		start, end time.Duration
+		rects      []image.Rectangle
+		alpha      uint8
+	}{
+		{1 * time.Second, 2 * time.Second, []image.Rectangle{image.Rect(1, 1, 5, 3), image.Rect(10, 20, 14, 22)}, 0xff},
+		{2 * time.Second, 4 * time.Second, []image.Rectangle{image.Rect(1, 1, 5, 3), image.Rect(10, 20, 14, 22)}, 0x80},
+		{4 * time.Second, 5 * time.Second, []image.Rectangle{image.Rect(10, 20, 11, 22)}, 0x80},
+	}
+	check := func(name string, events []pgs.Event) {
+		if len(events) != len(want) {
+			t.Fatalf("%s: got %d events, want %d", name, len(events), len(want))
+		}
+		for i, w := range want {
+			ev := &events[i]
+			if ev.Start != w.start || ev.End != w.end {
+				t.Errorf("%s: event %d from %s to %s, want %s to %s", name, i, ev.Start, ev.End, w.start, w.end)
+			}
+			if len(ev.Images) != len(w.rects) {
+				t.Errorf("%s: event %d has %d images, want %d", name, i, len(ev.Images), len(w.rects))
+				continue
+			}
+			for j, img := range ev.Images {
+				if img.Rect() != w.rects[j] {
+					t.Errorf("%s: event %d image %d at %v, want %v", name, i, j, img.Rect(), w.rects[j])
+				}
+			}
+			if a := ev.Palette[1].A; a != w.alpha {
+				t.Errorf("%s: event %d has alpha 0x%x, want 0x%x", name, i, a, w.alpha)
+			}
+		}
+		if c := events[2].Images[0].Image.ColorIndexAt(0, 1); c != 2 {
+			t.Errorf("%s: cropped image has color %d, want 2", name, c)
+		}
+	}
+	check("extracted", events)
+
+	compiled, err := pgs.CompileEvents(events, pc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := []pgs.CompositionState{pgs.EpochStart, pgs.Normal, pgs.EpochStart, pgs.Normal}
+	if len(compiled) != len(states) {
+		t.Fatalf("compiled %d display sets, want %d", len(compiled), len(states))
+	}
+	for i, state := range states {
+		if compiled[i].CompositionState != state {
+			t.Errorf("display set %d is %s, want %s", i, compiled[i].CompositionState, state)
+		}
+	}
+	if !compiled[1].PaletteUpdate {
+		t.Error("display set 1 is not a palette update")
+	}
+	for _, f := range pgs.ValidateStream(compiled) {
+		if f.Severity == pgs.Error {
+			t.Errorf("compiled stream: %s", f)
+		}
+	}
+	recompiled, err := pgs.Events(compiled, pgs.ColorSpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("compiled", recompiled)
+}
@@ -0,0 +1,126 @@
+package pgs
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FrameRate is the frame rate code of the video in a presentation
+// composition.
+type FrameRate uint8
+
+const (
+	FrameRate23976 FrameRate = 0x10 // 24000/1001
+	FrameRate24    FrameRate = 0x20
+	FrameRate25    FrameRate = 0x30
+	FrameRate2997  FrameRate = 0x40 // 30000/1001
+	FrameRate50    FrameRate = 0x60
+	FrameRate5994  FrameRate = 0x70 // 60000/1001
+)
+
+// Rate is a frame rate in frames per second, as a fraction.
+type Rate struct {
+	Num, Den int64
+}
+
+var frameRates = []struct {
+	code FrameRate
+	rate Rate
+}{
+	{FrameRate23976, Rate{24000, 1001}},
+	{FrameRate24, Rate{24, 1}},
+	{FrameRate25, Rate{25, 1}},
+	{FrameRate2997, Rate{30000, 1001}},
+	{FrameRate50, Rate{50, 1}},
+	{FrameRate5994, Rate{60000, 1001}},
+}
+
+// Rate returns the frame rate of the code, if it is recognized.
+func (fr FrameRate) Rate() (Rate, bool) {
+	for _, f := range frameRates {
+		if f.code == fr {
+			return f.rate, true
+		}
+	}
+	return Rate{}, false
+}
+
+// FrameRate returns the frame rate code for the rate, if one exists.
+func (r Rate) FrameRate() (FrameRate, bool) {
+	for _, f := range frameRates {
+		if f.rate.Num*r.Den == r.Num*f.rate.Den {
+			return f.code, true
+		}
+	}
+	return 0, false
+}
+
+// ParseRate parses a frame rate as a fraction, such as 24000/1001, or a
+// decimal, such as 25 or 23.976. Decimals that approximate NTSC rates
+// of N*1000/1001 are parsed as the exact fraction.
+func ParseRate(s string) (Rate, error) {
+	if i := strings.IndexByte(s, '/'); i != -1 {
+		num, err1 := strconv.ParseInt(s[:i], 10, 64)
+		den, err2 := strconv.ParseInt(s[i+1:], 10, 64)
+		if err1 != nil || err2 != nil || num <= 0 || den <= 0 {
+			return Rate{}, fmt.Errorf("invalid frame rate: %q", s)
+		}
+		return Rate{num, den}, nil
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || r.Sign() <= 0 || !r.Num().IsInt64() || !r.Denom().IsInt64() {
+		return Rate{}, fmt.Errorf("invalid frame rate: %q", s)
+	}
+	if !r.IsInt() {
+		f, _ := r.Float64()
+		if n := math.Round(f * 1.001); math.Abs(f-n/1.001) < 0.005 {
+			return Rate{int64(n) * 1000, 1001}, nil
+		}
+	}
+	return Rate{r.Num().Int64(), r.Denom().Int64()}, nil
+}
+
+// Frame returns the number of the frame nearest to the time.
+func (r Rate) Frame(d time.Duration) int64 {
+	n := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(r.Num))
+	den := new(big.Int).Mul(big.NewInt(r.Den), big.NewInt(int64(time.Second)))
+	return roundDiv(n, den).Int64()
+}
+
+// Time returns the start time of the frame.
+func (r Rate) Time(frame int64) time.Duration {
+	n := new(big.Int).Mul(big.NewInt(frame), big.NewInt(r.Den))
+	n.Mul(n, big.NewInt(int64(time.Second)))
+	return time.Duration(roundDiv(n, big.NewInt(r.Num)).Int64())
+}
+
+func (r Rate) String() string {
+	if r.Den == 1 {
+		return strconv.FormatInt(r.Num, 10)
+	}
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+func (fr FrameRate) String() string {
+	if r, ok := fr.Rate(); ok {
+		return fmt.Sprintf("%s fps", r)
+	}
+	return fmt.Sprintf("0x%x", uint8(fr))
+}
+
+// roundDiv divides, rounding half away from zero.
+func roundDiv(n, d *big.Int) *big.Int {
+	q, m := new(big.Int).QuoRem(n, d, new(big.Int))
+	if m.Abs(m).Lsh(m, 1).CmpAbs(d) >= 0 {
+		if n.Sign()*d.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
@@ -0,0 +1,62 @@
+package pgs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		s    string
+		rate Rate
+		code FrameRate
+	}{
+		{"24000/1001", Rate{24000, 1001}, FrameRate23976},
+		{"23.976", Rate{24000, 1001}, FrameRate23976},
+		{"23.98", Rate{24000, 1001}, FrameRate23976},
+		{"29.97", Rate{30000, 1001}, FrameRate2997},
+		{"59.94", Rate{60000, 1001}, FrameRate5994},
+		{"24", Rate{24, 1}, FrameRate24},
+		{"25", Rate{25, 1}, FrameRate25},
+		{"50/1", Rate{50, 1}, FrameRate50},
+		{"12.5", Rate{25, 2}, 0},
+	}
+	for _, tt := range tests {
+		r, err := ParseRate(tt.s)
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+			continue
+		}
+		if r != tt.rate {
+			t.Errorf("%q: got %s, want %s", tt.s, r, tt.rate)
+		}
+		code, ok := r.FrameRate()
+		if ok != (tt.code != 0) || code != tt.code {
+			t.Errorf("%q: got frame rate code %s, want %s", tt.s, code, tt.code)
+		}
+	}
+	for _, s := range []string{"", "0", "-25", "25/0", "1/-2", "fast"} {
+		if r, err := ParseRate(s); err == nil {
+			t.Errorf("%q: got %s, want error", s, r)
+		}
+	}
+}
+
+func TestRateFrame(t *testing.T) {
+	ntsc := Rate{24000, 1001}
+	for _, frame := range []int64{0, 1, 2, 23, 24, 1000, 172800} {
+		d := ntsc.Time(frame)
+		if got := ntsc.Frame(d); got != frame {
+			t.Errorf("frame %d at %s: got frame %d", frame, d, got)
+		}
+		if got := ntsc.Frame(d + 10*time.Millisecond); got != frame {
+			t.Errorf("frame %d at %s+10ms: got frame %d", frame, d, got)
+		}
+	}
+	if d, want := ntsc.Time(24), 1001*time.Millisecond; d != want {
+		t.Errorf("frame 24 at %s, want %s", d, want)
+	}
+	if d, want := (Rate{25, 1}).Time(-25), -time.Second; d != want {
+		t.Errorf("frame -25 at %s, want %s", d, want)
+	}
+}
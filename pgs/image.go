@@ -1,19 +1,27 @@
 package pgs
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 )
 
-func (img *Image) Convert(p *Palette) (*image.Paletted, error) {
-	var cp color.Palette
-	ids := make([]uint8, len(p.Entries))
-	idMap := make(map[uint8]uint8)
-	for i, e := range p.Entries {
-		ids[i] = e.ID
-		idMap[e.ID] = uint8(i)
-		cp = append(cp, e.NYCbCrA)
+// Convert decodes the run-length encoded image with the palette, which
+// is converted to RGB in the color space. The color index of each pixel
+// is its palette entry ID and entries not defined by the palette are
+// transparent.
+func (img *Image) Convert(p *Palette, cs ColorSpace) (*image.Paletted, error) {
+	if p == nil {
+		return nil, errors.New("palette not defined")
+	}
+	cp := make(color.Palette, 256)
+	for i := range cp {
+		cp[i] = color.Transparent
+	}
+	for _, e := range p.Entries {
+		cp[e.ID] = cs.RGBA(e)
 	}
 	rect := image.Rectangle{Max: image.Point{int(img.Width), int(img.Height)}}
 	pimg := image.NewPaletted(rect, cp)
@@ -29,6 +37,9 @@ func (img *Image) Convert(p *Palette) (*image.Paletted, error) {
 		}
 		var c uint8
 		var l uint16
+		if i+rleLen(d[i+1:]) > len(d) {
+			return nil, fmt.Errorf("line %d truncated", y)
+		}
 
 		hd1, ld1 := d[i+1]&0xc0, d[i+1]&0x3f
 		switch hd1 {
@@ -52,12 +63,12 @@ func (img *Image) Convert(p *Palette) (*image.Paletted, error) {
 		// 00000000 10LLLLLL CCCCCCCC - L pixels in color C
 		case 0x80:
 			l = uint16(ld1)
-			c = idMap[d[i+2]]
+			c = d[i+2]
 			i += 3
 		// 00000000 11LLLLLL LLLLLLLL CCCCCCCC - L pixels in color C
 		case 0xc0:
 			l = uint16(ld1)<<8 | uint16(d[i+2])
-			c = idMap[d[i+3]]
+			c = d[i+3]
 			i += 4
 		default:
 			panic("impossible")
@@ -75,3 +86,109 @@ func (img *Image) Convert(p *Palette) (*image.Paletted, error) {
 	}
 	return pimg, nil
 }
+
+// NewObject run-length encodes an image as an object. When p is nil,
+// img must be an *image.Paletted, otherwise each pixel is mapped to the
+// closest color in p. The color index of each pixel is used as its
+// palette entry ID.
+func NewObject(id uint16, version uint8, img image.Image, p color.Palette) (*Object, error) {
+	pimg, ok := img.(*image.Paletted)
+	if p != nil {
+		pimg = Paletted(img, p)
+	} else if !ok {
+		return nil, errors.New("image not paletted and no palette given")
+	}
+	enc, err := EncodeImage(pimg)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{ID: id, Version: version, Image: *enc}, nil
+}
+
+// NewPalette creates a palette definition from a color palette, with
+// the index of each color as its entry ID, converting to YCbCr in the
+// color space.
+func NewPalette(id, version uint8, p color.Palette, cs ColorSpace) (*Palette, error) {
+	if len(p) > 256 {
+		return nil, fmt.Errorf("palette has %d colors, at most 256 allowed", len(p))
+	}
+	entries := make([]PaletteEntry, len(p))
+	for i, c := range p {
+		entries[i] = cs.Entry(uint8(i), c)
+	}
+	return &Palette{ID: id, Version: version, Entries: entries}, nil
+}
+
+// Paletted maps each pixel of an image to the closest color in the
+// palette.
+func Paletted(img image.Image, p color.Palette) *image.Paletted {
+	b := img.Bounds()
+	pimg := image.NewPaletted(image.Rectangle{Max: b.Size()}, p)
+	draw.Draw(pimg, pimg.Rect, img, b.Min, draw.Src)
+	return pimg
+}
+
+// EncodeImage run-length encodes a paletted image using the shortest
+// code for each run. The color index of each pixel is used as its
+// palette entry ID.
+func EncodeImage(img *image.Paletted) (*Image, error) {
+	b := img.Bounds()
+	if b.Dx() > 0xffff || b.Dy() > 0xffff {
+		return nil, fmt.Errorf("image dimensions %dx%d overflow", b.Dx(), b.Dy())
+	}
+	var d []byte
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := img.PixOffset(b.Min.X, y)
+		row := img.Pix[i : i+b.Dx()]
+		for x := 0; x < len(row); {
+			c := row[x]
+			l := 1
+			for x+l < len(row) && row[x+l] == c && l < 0x3fff {
+				l++
+			}
+			d = appendRun(d, c, l)
+			x += l
+		}
+		d = append(d, 0, 0) // End of line
+	}
+	if len(d) > 0xffffff-4 {
+		return nil, fmt.Errorf("object data length overflow: %d", len(d))
+	}
+	return &Image{Width: uint16(b.Dx()), Height: uint16(b.Dy()), Data: d}, nil
+}
+
+// appendRun appends the shortest code for l pixels in color c, where
+// 0 < l < 0x4000.
+func appendRun(d []byte, c uint8, l int) []byte {
+	switch {
+	case c == 0 && l < 0x40: // 00000000 00LLLLLL
+		return append(d, 0, uint8(l))
+	case c == 0: // 00000000 01LLLLLL LLLLLLLL
+		return append(d, 0, 0x40|uint8(l>>8), uint8(l))
+	case l <= 2: // CCCCCCCC
+		for i := 0; i < l; i++ {
+			d = append(d, c)
+		}
+		return d
+	case l < 0x40: // 00000000 10LLLLLL CCCCCCCC
+		return append(d, 0, 0x80|uint8(l), c)
+	default: // 00000000 11LLLLLL LLLLLLLL CCCCCCCC
+		return append(d, 0, 0xc0|uint8(l>>8), uint8(l), c)
+	}
+}
+
+// rleLen returns the length of a run-length code starting with a zero
+// byte, given the bytes following it.
+func rleLen(d []byte) int {
+	if len(d) == 0 {
+		return 2
+	}
+	switch d[0] & 0xc0 {
+	case 0x00:
+		return 2
+	case 0x40, 0x80:
+		return 3
+	default:
+		return 4
+	}
+}
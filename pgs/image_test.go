@@ -0,0 +1,120 @@
+package pgs
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeImage(t *testing.T) {
+	p := color.Palette{color.Transparent, color.White, color.Black}
+	img := image.NewPaletted(image.Rect(0, 0, 200, 2), p)
+	row := img.Pix[:200]
+	row[0] = 1            // Single pixel
+	row[1], row[2] = 2, 2 // Two single pixels
+	for x := 3; x < 10; x++ {
+		row[x] = 1 // Short run
+	}
+	// 10..59: short run of color 0
+	for x := 60; x < 200; x++ {
+		row[x] = 2 // Long run
+	}
+	// Second line: long run of color 0
+
+	enc, err := EncodeImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		1,
+		2, 2,
+		0, 0x80 | 7, 1,
+		0, 50,
+		0, 0xc0, 140, 2,
+		0, 0,
+		0, 0x40, 200,
+		0, 0,
+	}
+	if !bytes.Equal(enc.Data, want) {
+		t.Errorf("encoded % x, want % x", enc.Data, want)
+	}
+
+	pal, err := NewPalette(0, 0, p, JFIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := enc.Convert(pal, JFIF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec.Pix, img.Pix) {
+		t.Error("decoded image differs")
+	}
+}
+
+func TestColorSpace(t *testing.T) {
+	colors := []color.NRGBA{
+		{0, 0, 0, 255},
+		{255, 255, 255, 255},
+		{255, 0, 0, 128},
+		{0, 255, 0, 0},
+		{0, 0, 255, 255},
+		{200, 150, 30, 255},
+	}
+	for _, cs := range []ColorSpace{{BT601, false}, {BT601, true}, {BT709, false}, {BT709, true}} {
+		for _, c := range colors {
+			e := cs.Entry(0, c)
+			got := cs.RGBA(e)
+			if diff(got.R, c.R) > 2 || diff(got.G, c.G) > 2 || diff(got.B, c.B) > 2 || got.A != c.A {
+				t.Errorf("%s: %v converted to %v", cs, c, got)
+			}
+		}
+	}
+	// Full range BT.601 matches the standard library
+	e := JFIF.Entry(0, color.NRGBA{200, 150, 30, 255})
+	want := color.NYCbCrAModel.Convert(color.NRGBA{200, 150, 30, 255}).(color.NYCbCrA)
+	if diff(e.Y, want.Y) > 1 || diff(e.Cb, want.Cb) > 1 || diff(e.Cr, want.Cr) > 1 {
+		t.Errorf("JFIF entry %v, want %v", e.NYCbCrA, want)
+	}
+}
+
+func diff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestQuantize(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	red := color.NRGBA{255, 0, 0, 255}
+	for x := 0; x < 64; x++ {
+		img.Set(x, 0, red)
+		img.Set(x, 1, color.NRGBA{0, 0, 255, 128})
+	}
+	p := Quantize(img, 255)
+	if len(p) != 2 {
+		t.Fatalf("quantized %d exact colors to %v", 2, p)
+	}
+
+	// 4096 colors, excluding transparent
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), 128, 255})
+		}
+	}
+	p = Quantize(img, 255)
+	if len(p) != 255 {
+		t.Fatalf("quantized to %d colors, want 255", len(p))
+	}
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			c := img.NRGBAAt(x, y)
+			q := p[p.Index(c)].(color.NRGBA)
+			if diff(c.R, q.R) > 16 || diff(c.G, q.G) > 16 || diff(c.B, q.B) != 0 {
+				t.Fatalf("pixel %v quantized to %v", c, q)
+			}
+		}
+	}
+}
@@ -10,19 +10,23 @@ type DisplaySet struct {
 	PresentationTime time.Duration
 	DecodingTime     time.Duration
 	PresentationComposition
-	Windows []Window
-	Palette *Palette
-	Object  *Object
+	Windows  []Window
+	Palettes Palettes
+	Objects  []Object
 }
 
+// MaxTime is the greatest time representable by the 32-bit 90 kHz
+// timestamps of segment headers.
+const MaxTime = time.Duration(0xffffffff) * time.Millisecond / 90
+
 type PresentationComposition struct {
-	Width, Height     uint16 // Video dimensions in pixels
-	FrameRate         uint8  // Always 0x10; can be ignored
-	CompositionNumber uint16
-	CompositionState  CompositionState // Type of this composition
-	PaletteUpdate     bool
-	PaletteID         uint8
-	Objects           []CompositionObject
+	Width, Height      uint16    // Video dimensions in pixels
+	FrameRate          FrameRate // Usually 0x10; can be ignored
+	CompositionNumber  uint16
+	CompositionState   CompositionState // Type of this composition
+	PaletteUpdate      bool
+	PaletteID          uint8
+	CompositionObjects []CompositionObject
 }
 
 type CompositionObject struct {
@@ -33,7 +37,7 @@ type CompositionObject struct {
 }
 
 type CompositionObjectCrop struct {
-	// Offset of the cropped object from the top left pixel of the screen
+	// Offset of the cropped region from the top left pixel of the object
 	X, Y          uint16
 	Width, Height uint16 // Dimensions of the cropped object
 }
@@ -44,6 +48,10 @@ type Window struct {
 	Width, Height uint16
 }
 
+// Palettes is a collection of palette definitions, keyed by palette ID
+// and version.
+type Palettes []Palette
+
 type Palette struct {
 	ID      uint8
 	Version uint8
@@ -55,10 +63,12 @@ type PaletteEntry struct {
 	color.NYCbCrA
 }
 
+// Object is an object definition. Objects too large for a single
+// segment are split across several ODS segments in the stream, which
+// are reassembled into a single Object when read.
 type Object struct {
-	ID          uint16 // ID of this object
-	Version     uint8  // Version of this object
-	First, Last bool
+	ID      uint16 // ID of this object
+	Version uint8  // Version of this object
 	Image
 }
 
@@ -100,6 +110,14 @@ const (
 	Normal CompositionState = 0x00
 )
 
+func (typ SegmentType) known() bool {
+	switch typ {
+	case PCSType, WDSType, PDSType, ODSType, ENDType:
+		return true
+	}
+	return false
+}
+
 func (typ SegmentType) String() string {
 	switch typ {
 	case PCSType:
@@ -113,7 +131,47 @@ func (typ SegmentType) String() string {
 	case ENDType:
 		return "END"
 	}
-	return fmt.Sprintf("%x", string(typ))
+	return fmt.Sprintf("0x%x", uint8(typ))
+}
+
+// Get returns the palette with the given ID and version, or nil if it
+// is not defined.
+func (ps Palettes) Get(id, version uint8) *Palette {
+	for i := range ps {
+		if ps[i].ID == id && ps[i].Version == version {
+			return &ps[i]
+		}
+	}
+	return nil
+}
+
+// Find returns the last defined palette with the given ID, or nil if it
+// is not defined.
+func (ps Palettes) Find(id uint8) *Palette {
+	for i := len(ps) - 1; i >= 0; i-- {
+		if ps[i].ID == id {
+			return &ps[i]
+		}
+	}
+	return nil
+}
+
+// Palette returns the palette referenced by the composition, when it is
+// defined in this display set.
+func (ds *DisplaySet) Palette() *Palette {
+	return ds.Palettes.Find(ds.PaletteID)
+}
+
+func (cs CompositionState) String() string {
+	switch cs {
+	case EpochStart:
+		return "Epoch Start"
+	case AcquisitionPoint:
+		return "Acquisition Point"
+	case Normal:
+		return "Normal"
+	}
+	return fmt.Sprintf("0x%x", uint8(cs))
 }
 
 func (p *Palette) String() string {
@@ -124,6 +182,11 @@ func (pe PaletteEntry) String() string {
 	return fmt.Sprintf("{%d: %d %d %d %d}", pe.ID, pe.Y, pe.Cb, pe.Cr, pe.A)
 }
 
+func (f *ObjectFragment) String() string {
+	return fmt.Sprintf("{ID:%d Version:%d First:%t Last:%t DataLength:%d Width:%d Height:%d len:%d}",
+		f.ID, f.Version, f.First, f.Last, f.DataLength, f.Width, f.Height, len(f.Data))
+}
+
 func (img Image) String() string {
 	return fmt.Sprintf("{%dx%d len:%d}", img.Width, img.Height, len(img.Data))
 }
@@ -0,0 +1,40 @@
+// Package pgstest provides objects and palettes for tests of packages
+// that work with PGS streams.
+package pgstest
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// NewObject encodes an image of the palette entry IDs in pix, in rows
+// of width pixels, as an object.
+func NewObject(t testing.TB, id uint16, version uint8, width int, pix []uint8) pgs.Object {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, width, len(pix)/width), nil)
+	copy(img.Pix, pix)
+	obj, err := pgs.NewObject(id, version, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *obj
+}
+
+// NewPalettes returns palette 0 with entry 0 transparent and the
+// colors from entry 1, and its next version with entry 1 faded to half
+// opacity.
+func NewPalettes(t testing.TB, colors ...color.Color) (pal, faded pgs.Palette) {
+	t.Helper()
+	p, err := pgs.NewPalette(0, 0, append(color.Palette{color.Transparent}, colors...), pgs.ColorSpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	faded = *p
+	faded.Version = 1
+	faded.Entries = append([]pgs.PaletteEntry{}, p.Entries...)
+	faded.Entries[1].A = 0x80
+	return *p, faded
+}
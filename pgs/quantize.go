@@ -0,0 +1,140 @@
+package pgs
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// Quantize selects a palette of at most n colors for the image by
+// median cut. Fully transparent pixels are excluded, so that the caller
+// can reserve an entry for them.
+func Quantize(img image.Image, n int) color.Palette {
+	return quantize([]image.Image{img}, n)
+}
+
+// quantize selects a palette of at most n colors shared by the images.
+func quantize(imgs []image.Image, n int) color.Palette {
+	counts := make(map[color.NRGBA]int)
+	for _, img := range imgs {
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if c.A != 0 {
+					counts[c]++
+				}
+			}
+		}
+	}
+	colors := make([]colorCount, 0, len(counts))
+	for c, count := range counts {
+		colors = append(colors, colorCount{c, count})
+	}
+	// Sort for a deterministic result
+	sort.Slice(colors, func(i, j int) bool {
+		return colors[i].key() < colors[j].key()
+	})
+	if len(colors) <= n {
+		p := make(color.Palette, len(colors))
+		for i, c := range colors {
+			p[i] = c.c
+		}
+		return p
+	}
+
+	boxes := [][]colorCount{colors}
+	for len(boxes) < n {
+		// Split the box with the widest range in any channel
+		split, ch, width := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, w := widestChannel(box); w > width {
+				split, ch, width = i, c, w
+			}
+		}
+		if split == -1 {
+			break
+		}
+		box := boxes[split]
+		sort.SliceStable(box, func(i, j int) bool {
+			return box[i].channel(ch) < box[j].channel(ch)
+		})
+		total := 0
+		for _, c := range box {
+			total += c.count
+		}
+		// Cut at the weighted median, leaving both halves non-empty
+		m, sum := 1, box[0].count
+		for m < len(box)-1 && 2*sum < total {
+			sum += box[m].count
+			m++
+		}
+		boxes[split] = box[:m]
+		boxes = append(boxes, box[m:])
+	}
+
+	p := make(color.Palette, len(boxes))
+	for i, box := range boxes {
+		var r, g, b, a, total int
+		for _, c := range box {
+			r += int(c.c.R) * c.count
+			g += int(c.c.G) * c.count
+			b += int(c.c.B) * c.count
+			a += int(c.c.A) * c.count
+			total += c.count
+		}
+		p[i] = color.NRGBA{
+			uint8((r + total/2) / total),
+			uint8((g + total/2) / total),
+			uint8((b + total/2) / total),
+			uint8((a + total/2) / total),
+		}
+	}
+	return p
+}
+
+type colorCount struct {
+	c     color.NRGBA
+	count int
+}
+
+func (c colorCount) key() uint32 {
+	return uint32(c.c.R)<<24 | uint32(c.c.G)<<16 | uint32(c.c.B)<<8 | uint32(c.c.A)
+}
+
+func (c colorCount) channel(ch int) uint8 {
+	switch ch {
+	case 0:
+		return c.c.R
+	case 1:
+		return c.c.G
+	case 2:
+		return c.c.B
+	default:
+		return c.c.A
+	}
+}
+
+// widestChannel returns the channel with the widest range of values in
+// the box and the width of that range.
+func widestChannel(box []colorCount) (ch, width int) {
+	for i := 0; i < 4; i++ {
+		min, max := 255, 0
+		for _, c := range box {
+			v := int(c.channel(i))
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if max-min > width {
+			ch, width = i, max-min
+		}
+	}
+	return ch, width
+}
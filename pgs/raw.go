@@ -1,21 +1,10 @@
 package pgs
 
-import (
-	"fmt"
-	"time"
-)
-
-type header struct {
-	MagicNumber      uint16    // "PG" 0x5047
-	PresentationTime timestamp // When sub picture is shown on screen
-	DecodingTime     timestamp // When sub picture decoding starts
-	SegmentType      SegmentType
-	SegmentSize      uint16
-}
+import "fmt"
 
 type pcs struct {
-	Width, Height     uint16 // Video dimensions in pixels
-	FrameRate         uint8  // Always 0x10; can be ignored
+	Width, Height     uint16    // Video dimensions in pixels
+	FrameRate         FrameRate // Usually 0x10; can be ignored
 	CompositionNumber uint16
 	CompositionState  CompositionState // Type of this composition
 	PaletteUpdateFlag paletteUpdateFlag
@@ -40,16 +29,19 @@ type pds struct {
 }
 
 type ods struct {
-	ObjectID         uint16       // ID of this object
-	ObjectVersion    uint8        // Version of this object
-	SequenceFlag     sequenceFlag // If the image is split into a series of consecutive fragments, the last fragment has this flag set. Possible values:
-	ObjectDataLength uint24       // The length of the Run-length Encoding (RLE) data buffer with the compressed image data.
-	Width, Height    uint16       // Dimensions of the image
+	ObjectID      uint16       // ID of this object
+	ObjectVersion uint8        // Version of this object
+	SequenceFlag  sequenceFlag // If the image is split into a series of consecutive fragments, the first and last fragments have these flags set
+}
+
+// odsFirst follows ods in the first fragment of an object.
+type odsFirst struct {
+	ObjectDataLength uint24 // The length of the Run-length Encoding (RLE) data buffer with the compressed image data.
+	Width, Height    uint16 // Dimensions of the image
 }
 
 type (
-	timestamp uint32
-	uint24    [3]uint8
+	uint24 [3]uint8
 
 	paletteUpdateFlag uint8
 	objectCroppedFlag uint8
@@ -65,17 +57,12 @@ const (
 
 	lastInSequence  sequenceFlag = 0x40
 	firstInSequence sequenceFlag = 0x80
-)
-
-// Duration converts a timestamp into a Duration. Timestamps have an
-// accuracy of 90 kHz, so divide by 90 to get milliseconds.
-func (ts timestamp) Duration() time.Duration {
-	return time.Duration(ts) * time.Millisecond / 90
-}
 
-func fromDuration(d time.Duration) timestamp {
-	return timestamp(d * 90 / time.Millisecond)
-}
+	// Maximum object data in the first and subsequent fragments of an
+	// object, such that the segment size fits in 16 bits
+	maxFirstFragment = 0xffff - 11
+	maxNextFragment  = 0xffff - 4
+)
 
 func (ui uint24) Int() int {
 	return int(ui[0])<<16 | int(ui[1])<<8 | int(ui[2])
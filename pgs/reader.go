@@ -1,18 +1,36 @@
 package pgs
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 )
 
 type Reader struct {
-	r io.Reader
+	sr      *SegmentReader
+	pending *Segment // Segment read ahead of the current display set
 }
 
 func NewReader(r io.Reader) *Reader {
-	return &Reader{r}
+	return &Reader{sr: NewSegmentReader(r)}
+}
+
+// NewRecoveringReader creates a reader that repairs damaged streams,
+// rather than failing on the first error. In addition to the repairs of
+// a recovering segment reader, it skips stray segments outside of
+// display sets and ends display sets that are missing an END segment.
+// Each repair is reported as a warning.
+func NewRecoveringReader(r io.Reader) *Reader {
+	return &Reader{sr: NewRecoveringSegmentReader(r)}
+}
+
+// Warnings returns the repairs made so far by a recovering reader.
+func (r *Reader) Warnings() []Repair {
+	return r.sr.warnings
+}
+
+func (r *Reader) repair(off int64, err error) error {
+	return r.sr.repair(off, err)
 }
 
 func (r *Reader) ReadAll() ([]DisplaySet, error) {
@@ -32,203 +50,189 @@ func (r *Reader) ReadAll() ([]DisplaySet, error) {
 func (r *Reader) Read() (*DisplaySet, error) {
 	var ds DisplaySet
 
-	h0, err := r.readHeader()
+	s0, err := r.nextSegment()
 	if err == io.EOF {
 		return nil, err
 	}
 	if err != nil {
 		return nil, fmt.Errorf("segment header: %w", err)
 	}
-	if h0.SegmentType != PCSType {
-		return nil, fmt.Errorf("segment not PCS: %s", h0.SegmentType)
-	}
-	c, err := r.readPresentationComposition(h0.SegmentSize)
-	if err != nil {
-		return nil, fmt.Errorf("presentation composition segment: %w", err)
+	var c *PresentationComposition
+	for c == nil {
+		if s0.SegmentType != PCSType {
+			err = fmt.Errorf("segment not PCS: %s", s0.SegmentType)
+		} else if c, err = s0.presentationComposition(r.repairAt(s0)); err != nil {
+			err = fmt.Errorf("presentation composition segment: %w", err)
+		}
+		if err != nil {
+			if err := r.skip(s0, err); err != nil {
+				return nil, err
+			}
+			if s0, err = r.nextSegment(); err != nil {
+				return nil, err
+			}
+		}
 	}
+	h0 := s0.SegmentHeader
 	ds.PresentationTime = h0.PresentationTime.Duration()
 	ds.DecodingTime = h0.DecodingTime.Duration()
 	ds.PresentationComposition = *c
 
+	var obj *Object // Object with fragments pending
+	dataLen := 0
 	for {
-		h, err := r.readHeader()
+		s, err := r.nextSegment()
+		if err == io.EOF && r.sr.recover {
+			r.repair(r.sr.off, errors.New("display set not ended at end of stream"))
+			if obj != nil {
+				r.repair(r.sr.off, fmt.Errorf("dropped object %d not terminated", obj.ID))
+			}
+			return &ds, nil
+		}
 		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
 			return nil, fmt.Errorf("segment header: %w", err)
 		}
-		if h.PresentationTime != h0.PresentationTime {
-			return nil, fmt.Errorf("presentation time not consistent: PCS is %s, %s is %s",
-				ds.PresentationTime, h.SegmentType, h.PresentationTime.Duration())
+		if s.SegmentType == PCSType {
+			if !r.sr.recover {
+				return nil, errors.New("presentation composition not ended")
+			}
+			r.repair(s.Offset, errors.New("display set not ended before next PCS"))
+			if obj != nil {
+				r.repair(s.Offset, fmt.Errorf("dropped object %d not terminated", obj.ID))
+			}
+			r.pending = s
+			return &ds, nil
+		}
+		if s.PresentationTime != h0.PresentationTime {
+			if err := r.repair(s.Offset, fmt.Errorf("presentation time not consistent: PCS is %s, %s is %s",
+				ds.PresentationTime, s.SegmentType, s.PresentationTime.Duration())); err != nil {
+				return nil, err
+			}
 		}
-		if h.DecodingTime != h0.DecodingTime {
-			return nil, fmt.Errorf("decoding time not consistent: PCS is %s, %s is %s",
-				ds.DecodingTime, h.SegmentType, h.DecodingTime.Duration())
+		if s.DecodingTime != h0.DecodingTime {
+			if err := r.repair(s.Offset, fmt.Errorf("decoding time not consistent: PCS is %s, %s is %s",
+				ds.DecodingTime, s.SegmentType, s.DecodingTime.Duration())); err != nil {
+				return nil, err
+			}
 		}
 
-		switch h.SegmentType {
-		case PCSType:
-			return nil, errors.New("presentation composition not ended")
+		switch s.SegmentType {
 		case WDSType:
 			if len(ds.Windows) != 0 {
-				return nil, errors.New("multiple window definitions")
+				if err := r.repair(s.Offset, errors.New("multiple window definitions")); err != nil {
+					return nil, err
+				}
 			}
-			w, err := r.readWindows(h.SegmentSize)
+			w, err := s.windows(r.repairAt(s))
 			if err != nil {
-				return nil, fmt.Errorf("window definition segment: %w", err)
+				if err := r.skip(s, fmt.Errorf("window definition segment: %w", err)); err != nil {
+					return nil, err
+				}
+				continue
 			}
-			ds.Windows = w
+			ds.Windows = append(ds.Windows, w...)
 		case PDSType:
-			if ds.Palette != nil {
-				return nil, errors.New("multiple palette definitions")
-			}
-			p, err := r.readPalette(h.SegmentSize)
+			p, err := s.palette(r.repairAt(s))
 			if err != nil {
-				return nil, fmt.Errorf("palette definition segment: %w", err)
+				if err := r.skip(s, fmt.Errorf("palette definition segment: %w", err)); err != nil {
+					return nil, err
+				}
+				continue
 			}
-			ds.Palette = p
-		case ODSType:
-			if ds.Object != nil {
-				return nil, errors.New("multiple object definitions")
+			if ds.Palettes.Get(p.ID, p.Version) != nil {
+				if err := r.repair(s.Offset, fmt.Errorf("palette %d version %d defined multiple times",
+					p.ID, p.Version)); err != nil {
+					return nil, err
+				}
+				continue
 			}
-			o, err := r.readObject(h.SegmentSize)
+			ds.Palettes = append(ds.Palettes, *p)
+		case ODSType:
+			f, err := s.objectFragment(r.repairAt(s))
 			if err != nil {
-				return nil, fmt.Errorf("object definition segment: %w", err)
+				if err := r.skip(s, fmt.Errorf("object definition segment: %w", err)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if f.First {
+				if obj != nil {
+					if err := r.repair(s.Offset, fmt.Errorf("object %d not terminated before object %d",
+						obj.ID, f.ID)); err != nil {
+						return nil, err
+					}
+				}
+				obj = &Object{
+					ID:      f.ID,
+					Version: f.Version,
+					Image:   Image{Width: f.Width, Height: f.Height, Data: f.Data},
+				}
+				dataLen = f.DataLength
+			} else {
+				if obj == nil {
+					if err := r.repair(s.Offset, fmt.Errorf("object %d fragment without first in sequence",
+						f.ID)); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if f.ID != obj.ID || f.Version != obj.Version {
+					if err := r.repair(s.Offset, fmt.Errorf("object %d version %d fragment interleaved with object %d version %d",
+						f.ID, f.Version, obj.ID, obj.Version)); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				obj.Data = append(obj.Data, f.Data...)
+			}
+			if len(obj.Data) > dataLen || f.Last && len(obj.Data) != dataLen {
+				if err := r.repair(s.Offset, fmt.Errorf("object %d has %d bytes of data, %d bytes declared",
+					obj.ID, len(obj.Data), dataLen)); err != nil {
+					return nil, err
+				}
+				dataLen = len(obj.Data)
+			}
+			if f.Last {
+				ds.Objects = append(ds.Objects, *obj)
+				obj = nil
 			}
-			ds.Object = o
 		case ENDType:
-			return &ds, nil
-		}
-	}
-}
-
-func (r *Reader) readHeader() (*header, error) {
-	var h header
-	if err := binary.Read(r.r, binary.BigEndian, &h); err != nil {
-		return nil, err
-	}
-	if err := h.validate(); err != nil {
-		return nil, err
-	}
-	return &h, nil
-}
-
-func (r *Reader) readPresentationComposition(segmentSize uint16) (*PresentationComposition, error) {
-	var pcs pcs
-	if err := binary.Read(r.r, binary.BigEndian, &pcs); err != nil {
-		return nil, err
-	}
-	if err := pcs.validate(); err != nil {
-		return nil, err
-	}
-	size := 11
-	objects := make([]CompositionObject, pcs.ObjectCount)
-	for i := range objects {
-		var obj pcsObject
-		if err := binary.Read(r.r, binary.BigEndian, &obj); err != nil {
-			return nil, err
-		}
-		if err := obj.validate(); err != nil {
-			return nil, fmt.Errorf("composition object %d/%d: %w", i+1, pcs.ObjectCount, err)
-		}
-		objects[i] = CompositionObject{
-			ObjectID: obj.ObjectID,
-			WindowID: obj.WindowID,
-			X:        obj.X,
-			Y:        obj.Y,
-		}
-		if obj.ObjectCropped == croppedForce {
-			var crop CompositionObjectCrop
-			if err := binary.Read(r.r, binary.BigEndian, &crop); err != nil {
-				return nil, err
+			if obj != nil {
+				if err := r.repair(s.Offset, fmt.Errorf("object %d not terminated", obj.ID)); err != nil {
+					return nil, err
+				}
 			}
-			objects[i].Crop = &crop
-			size += 8
+			return &ds, nil
 		}
-		size += 8
-	}
-	if size != int(segmentSize) {
-		return nil, fmt.Errorf("read %d bytes, %d bytes declared in header", size, segmentSize)
 	}
-	pc := &PresentationComposition{
-		Width:             pcs.Width,
-		Height:            pcs.Height,
-		FrameRate:         pcs.FrameRate,
-		CompositionNumber: pcs.CompositionNumber,
-		CompositionState:  pcs.CompositionState,
-		PaletteUpdate:     pcs.PaletteUpdateFlag&pufTrue != 0,
-		PaletteID:         pcs.PaletteID,
-		Objects:           objects,
-	}
-	return pc, nil
 }
 
-func (r *Reader) readWindows(segmentSize uint16) ([]Window, error) {
-	var wds wds
-	if err := binary.Read(r.r, binary.BigEndian, &wds); err != nil {
-		return nil, err
-	}
-	if err := wds.validate(segmentSize); err != nil {
-		return nil, err
-	}
-	windows := make([]Window, wds.WindowCount)
-	for i := range windows {
-		if err := binary.Read(r.r, binary.BigEndian, &windows[i]); err != nil {
-			return nil, err
-		}
-	}
-	return windows, nil
+// skip reports a segment that cannot be decoded as skipped when
+// recovering, or otherwise returns the error.
+func (r *Reader) skip(s *Segment, err error) error {
+	if !r.sr.recover {
+		return err
+	}
+	r.repair(s.Offset, fmt.Errorf("skipped segment: %w", err))
+	return nil
 }
 
-func (r *Reader) readPalette(segmentSize uint16) (*Palette, error) {
-	var pds pds
-	if err := binary.Read(r.r, binary.BigEndian, &pds); err != nil {
-		return nil, err
-	}
-	n := (segmentSize - 2) / 5
-	entries := make([]PaletteEntry, n)
-	for i := range entries {
-		if err := binary.Read(r.r, binary.BigEndian, &entries[i]); err != nil {
-			return nil, err
-		}
-	}
-	p := &Palette{
-		ID:      pds.PaletteID,
-		Version: pds.PaletteVersion,
-		Entries: entries,
-	}
-	if err := p.validate(segmentSize); err != nil {
-		return nil, err
-	}
-	return p, nil
+// nextSegment returns the segment read ahead, if any, or reads the next
+// segment.
+func (r *Reader) nextSegment() (*Segment, error) {
+	if s := r.pending; s != nil {
+		r.pending = nil
+		return s, nil
+	}
+	return r.sr.Read()
 }
 
-func (r *Reader) readObject(segmentSize uint16) (*Object, error) {
-	var ods ods
-	if err := binary.Read(r.r, binary.BigEndian, &ods); err != nil {
-		return nil, err
-	}
-	if err := ods.validate(segmentSize); err != nil {
-		return nil, err
-	}
-	dataLen := ods.ObjectDataLength.Int() - 4
-	data := make([]byte, dataLen)
-	n := 0
-	for n < dataLen {
-		n0, err := r.r.Read(data[n:])
-		if err != nil {
-			return nil, err
-		}
-		n += n0
-	}
-	obj := &Object{
-		ID:      ods.ObjectID,
-		Version: ods.ObjectVersion,
-		First:   ods.SequenceFlag&firstInSequence != 0,
-		Last:    ods.SequenceFlag&lastInSequence != 0,
-		Image: Image{
-			Width:  ods.Width,
-			Height: ods.Height,
-			Data:   data,
-		},
+// repairAt returns a repair function for errors in the segment.
+func (r *Reader) repairAt(s *Segment) func(error) error {
+	return func(err error) error {
+		return r.repair(s.Offset, err)
 	}
-	return obj, nil
 }
@@ -0,0 +1,123 @@
+package pgs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecoveringReader(t *testing.T) {
+	pc := PresentationComposition{Width: 720, Height: 480, CompositionState: EpochStart}
+	stream := []DisplaySet{
+		{PresentationTime: 1 * time.Second, PresentationComposition: pc},
+		{PresentationTime: 2 * time.Second, PresentationComposition: pc},
+		{PresentationTime: 3 * time.Second, PresentationComposition: pc},
+	}
+	stream[1].Palettes = Palettes{{Entries: []PaletteEntry{{ID: 1}}}}
+	stream[2].Objects = []Object{{Image: Image{Width: 1, Height: 1, Data: []byte{1, 0, 0}}}}
+	var sets [][]byte
+	for i := range stream {
+		var buf bytes.Buffer
+		if err := NewWriter(&buf).Write(&stream[i]); err != nil {
+			t.Fatal(err)
+		}
+		sets = append(sets, buf.Bytes())
+	}
+
+	var damaged []byte
+	damaged = append(damaged, sets[0]...)
+	// Segment with an unrecognized type
+	damaged = append(damaged, 'P', 'G', 0, 0, 0, 0, 0, 0, 0, 0, 0x99, 0, 2, 0xab, 0xcd)
+	damaged = append(damaged, "garbage"...)
+	// Missing END segment
+	damaged = append(damaged, sets[1][:len(sets[1])-13]...)
+	damaged = append(damaged, sets[2]...)
+	// Truncated segment at end of stream
+	damaged = append(damaged, sets[0][:20]...)
+
+	if _, err := NewReader(bytes.NewReader(damaged)).ReadAll(); err == nil {
+		t.Error("damaged stream read without error")
+	}
+	r := NewRecoveringReader(bytes.NewReader(damaged))
+	got, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).WriteAll(got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), bytes.Join(sets, nil)) {
+		t.Errorf("read %+v, want %+v", got, stream)
+	}
+	want := []string{
+		"offset 0x25: skipped segment: unrecognized segment type: 0x99",
+		"offset 0x34: skipped 7 bytes before next magic number",
+		"offset 0x67: display set not ended before next PCS",
+		"offset 0xa7: skipped truncated PCS segment: unexpected EOF",
+	}
+	var warnings []string
+	for _, w := range r.Warnings() {
+		warnings = append(warnings, w.String())
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings:\n%q\nwant:\n%q", warnings, want)
+	}
+}
+
+func TestRecoveringReaderPayloads(t *testing.T) {
+	pc := PresentationComposition{Width: 720, Height: 480, CompositionState: EpochStart}
+	stream := []DisplaySet{
+		{PresentationTime: 1 * time.Second, PresentationComposition: pc},
+		{PresentationTime: 2 * time.Second, PresentationComposition: pc},
+		{PresentationTime: 3 * time.Second, PresentationComposition: pc},
+	}
+	var sets [][]byte
+	for i := range stream {
+		var buf bytes.Buffer
+		if err := NewWriter(&buf).Write(&stream[i]); err != nil {
+			t.Fatal(err)
+		}
+		sets = append(sets, buf.Bytes())
+	}
+
+	// After the PCS of the second display set, a WDS without its window
+	// count and an ODS with an unknown sequence flag
+	h := sets[1][:10] // Magic number and timestamps
+	pcsEnd := 13 + 11
+	var damaged []byte
+	damaged = append(damaged, sets[0]...)
+	damaged = append(damaged, sets[1][:pcsEnd]...)
+	damaged = append(append(damaged, h...), byte(WDSType), 0, 0)
+	damaged = append(append(damaged, h...), byte(ODSType), 0, 4, 0, 0, 0, 0x20)
+	damaged = append(damaged, sets[1][pcsEnd:]...)
+	damaged = append(damaged, sets[2]...)
+
+	if _, err := NewReader(bytes.NewReader(damaged)).ReadAll(); err == nil {
+		t.Error("damaged stream read without error")
+	}
+	r := NewRecoveringReader(bytes.NewReader(damaged))
+	got, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).WriteAll(got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), bytes.Join(sets, nil)) {
+		t.Errorf("read %+v, want %+v", got, stream)
+	}
+	want := []string{
+		"offset 0x3d: skipped segment: window definition segment: EOF",
+		"offset 0x4a: skipped segment: object definition segment: unrecognized flag: 0x20",
+	}
+	var warnings []string
+	for _, w := range r.Warnings() {
+		warnings = append(warnings, w.String())
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings:\n%q\nwant:\n%q", warnings, want)
+	}
+}
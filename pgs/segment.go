@@ -0,0 +1,426 @@
+package pgs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MagicNumber begins every segment header: "PG" 0x5047.
+const MagicNumber = 0x5047
+
+type SegmentHeader struct {
+	MagicNumber      uint16    // "PG" 0x5047
+	PresentationTime Timestamp // When sub picture is shown on screen
+	DecodingTime     Timestamp // When sub picture decoding starts
+	SegmentType      SegmentType
+	SegmentSize      uint16
+}
+
+// Timestamp is a time in ticks of a 90 kHz clock.
+type Timestamp uint32
+
+// Duration converts a timestamp into a Duration. Timestamps have an
+// accuracy of 90 kHz, so divide by 90 to get milliseconds.
+func (ts Timestamp) Duration() time.Duration {
+	return time.Duration(ts) * time.Millisecond / 90
+}
+
+// NewTimestamp converts a Duration to the nearest timestamp, so that
+// converting a timestamp to a Duration and back is lossless.
+func NewTimestamp(d time.Duration) Timestamp {
+	return Timestamp((d*90 + time.Millisecond/2) / time.Millisecond)
+}
+
+// Segment is a single segment of a stream, with its header and raw
+// payload.
+type Segment struct {
+	SegmentHeader
+	Offset int64 // Byte offset of the segment header in the stream
+	Data   []byte
+}
+
+// ObjectFragment is the payload of an ODS segment. Objects too large
+// for a single segment are split into several fragments and only the
+// first fragment has the length and dimensions of the object.
+type ObjectFragment struct {
+	ID          uint16 // ID of the object
+	Version     uint8  // Version of the object
+	First, Last bool   // Position of this fragment in the sequence
+	DataLength  int    // Length of the object data in all fragments, if first
+	Width       uint16 // Width of the object, if first
+	Height      uint16 // Height of the object, if first
+	Data        []byte
+}
+
+// PresentationComposition decodes the payload of a PCS segment.
+func (s *Segment) PresentationComposition() (*PresentationComposition, error) {
+	return s.presentationComposition(strict)
+}
+
+// Windows decodes the payload of a WDS segment.
+func (s *Segment) Windows() ([]Window, error) {
+	return s.windows(strict)
+}
+
+// Palette decodes the payload of a PDS segment.
+func (s *Segment) Palette() (*Palette, error) {
+	return s.palette(strict)
+}
+
+// ObjectFragment decodes the payload of an ODS segment.
+func (s *Segment) ObjectFragment() (*ObjectFragment, error) {
+	return s.objectFragment(strict)
+}
+
+// Payload decodes the payload of the segment by its type, as one of
+// *PresentationComposition, []Window, *Palette, *ObjectFragment, or nil
+// for an END segment.
+func (s *Segment) Payload() (interface{}, error) {
+	switch s.SegmentType {
+	case PCSType:
+		return s.PresentationComposition()
+	case WDSType:
+		return s.Windows()
+	case PDSType:
+		return s.Palette()
+	case ODSType:
+		return s.ObjectFragment()
+	case ENDType:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unrecognized segment type: %s", s.SegmentType)
+}
+
+// strict fails on every repairable error.
+func strict(err error) error {
+	return err
+}
+
+// The payload decoders pass errors, which a recovering reader can
+// tolerate, to repair.
+
+func (s *Segment) presentationComposition(repair func(error) error) (*PresentationComposition, error) {
+	br := bytes.NewReader(s.Data)
+	var pcs pcs
+	if err := binary.Read(br, binary.BigEndian, &pcs); err != nil {
+		return nil, err
+	}
+	if err := pcs.validate(); err != nil {
+		return nil, err
+	}
+	objects := make([]CompositionObject, pcs.ObjectCount)
+	for i := range objects {
+		var obj pcsObject
+		if err := binary.Read(br, binary.BigEndian, &obj); err != nil {
+			return nil, err
+		}
+		if err := obj.validate(); err != nil {
+			return nil, fmt.Errorf("composition object %d/%d: %w", i+1, pcs.ObjectCount, err)
+		}
+		objects[i] = CompositionObject{
+			ObjectID: obj.ObjectID,
+			WindowID: obj.WindowID,
+			X:        obj.X,
+			Y:        obj.Y,
+		}
+		if obj.ObjectCropped == croppedForce {
+			var crop CompositionObjectCrop
+			if err := binary.Read(br, binary.BigEndian, &crop); err != nil {
+				return nil, err
+			}
+			objects[i].Crop = &crop
+		}
+	}
+	if br.Len() != 0 {
+		if err := repair(fmt.Errorf("read %d bytes, %d bytes declared in header",
+			len(s.Data)-br.Len(), len(s.Data))); err != nil {
+			return nil, err
+		}
+	}
+	pc := &PresentationComposition{
+		Width:              pcs.Width,
+		Height:             pcs.Height,
+		FrameRate:          pcs.FrameRate,
+		CompositionNumber:  pcs.CompositionNumber,
+		CompositionState:   pcs.CompositionState,
+		PaletteUpdate:      pcs.PaletteUpdateFlag&pufTrue != 0,
+		PaletteID:          pcs.PaletteID,
+		CompositionObjects: objects,
+	}
+	return pc, nil
+}
+
+func (s *Segment) windows(repair func(error) error) ([]Window, error) {
+	br := bytes.NewReader(s.Data)
+	var wds wds
+	if err := binary.Read(br, binary.BigEndian, &wds); err != nil {
+		return nil, err
+	}
+	n := int(wds.WindowCount)
+	if err := wds.validate(uint16(len(s.Data))); err != nil {
+		if err := repair(err); err != nil {
+			return nil, err
+		}
+		if max := (len(s.Data) - 1) / 9; n > max {
+			n = max
+		}
+	}
+	windows := make([]Window, n)
+	for i := range windows {
+		if err := binary.Read(br, binary.BigEndian, &windows[i]); err != nil {
+			return nil, err
+		}
+	}
+	return windows, nil
+}
+
+func (s *Segment) palette(repair func(error) error) (*Palette, error) {
+	br := bytes.NewReader(s.Data)
+	var pds pds
+	if err := binary.Read(br, binary.BigEndian, &pds); err != nil {
+		return nil, err
+	}
+	entries := make([]PaletteEntry, br.Len()/5)
+	for i := range entries {
+		if err := binary.Read(br, binary.BigEndian, &entries[i]); err != nil {
+			return nil, err
+		}
+	}
+	p := &Palette{
+		ID:      pds.PaletteID,
+		Version: pds.PaletteVersion,
+		Entries: entries,
+	}
+	if err := p.validate(uint16(len(s.Data))); err != nil {
+		if err := repair(err); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (s *Segment) objectFragment(repair func(error) error) (*ObjectFragment, error) {
+	br := bytes.NewReader(s.Data)
+	var ods ods
+	if err := binary.Read(br, binary.BigEndian, &ods); err != nil {
+		return nil, err
+	}
+	if err := ods.validate(); err != nil {
+		return nil, err
+	}
+	f := &ObjectFragment{
+		ID:      ods.ObjectID,
+		Version: ods.ObjectVersion,
+		First:   ods.SequenceFlag&firstInSequence != 0,
+		Last:    ods.SequenceFlag&lastInSequence != 0,
+	}
+	if f.First {
+		var first odsFirst
+		if err := binary.Read(br, binary.BigEndian, &first); err != nil {
+			return nil, err
+		}
+		if err := first.validate(uint16(len(s.Data)), f.Last); err != nil {
+			if err := repair(err); err != nil {
+				return nil, err
+			}
+		}
+		f.Width = first.Width
+		f.Height = first.Height
+		f.DataLength = first.ObjectDataLength.Int() - 4
+	}
+	f.Data = s.Data[len(s.Data)-br.Len():]
+	return f, nil
+}
+
+// SegmentReader reads the individual segments of a stream.
+type SegmentReader struct {
+	r        io.Reader
+	br       *bufio.Reader // Buffer for resyncing, only when recovering
+	off      int64         // Offset of the next byte in the stream
+	recover  bool
+	warnings []Repair
+}
+
+// Repair is a repair made to a damaged stream by a recovering reader,
+// which is reported as a warning.
+type Repair struct {
+	Offset int64 // Byte offset of the repaired segment
+	Err    error
+}
+
+func (rep Repair) String() string {
+	return fmt.Sprintf("offset 0x%x: %v", rep.Offset, rep.Err)
+}
+
+// NewSegmentReader creates a segment reader that reads exactly the bytes
+// of each segment from r, without reading ahead.
+func NewSegmentReader(r io.Reader) *SegmentReader {
+	return &SegmentReader{r: r}
+}
+
+// NewRecoveringSegmentReader creates a segment reader that repairs
+// damaged streams. It resyncs to the next "PG" magic number after a
+// bad header, skips segments with unrecognized types or truncated
+// payloads, and tolerates inconsistent header fields. To resync, it
+// reads ahead of the current segment.
+func NewRecoveringSegmentReader(r io.Reader) *SegmentReader {
+	br := bufio.NewReader(r)
+	return &SegmentReader{r: br, br: br, recover: true}
+}
+
+// Warnings returns the repairs made so far by a recovering reader.
+func (r *SegmentReader) Warnings() []Repair {
+	return r.warnings
+}
+
+// Offset returns the byte offset of the next segment in the stream.
+func (r *SegmentReader) Offset() int64 {
+	return r.off
+}
+
+// repair records err as a warning when recovering and otherwise returns
+// it.
+func (r *SegmentReader) repair(off int64, err error) error {
+	if !r.recover {
+		return err
+	}
+	r.warnings = append(r.warnings, Repair{off, err})
+	return nil
+}
+
+// Read reads a segment header and its payload. When recovering, bytes
+// are skipped until the next valid header and segments with
+// unrecognized types are skipped.
+func (r *SegmentReader) Read() (*Segment, error) {
+	if !r.recover {
+		return r.read()
+	}
+	for {
+		off := r.off
+		b, err := r.br.Peek(13)
+		if len(b) == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		if len(b) < 13 {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			r.repair(off, fmt.Errorf("skipped %d trailing bytes: %w", len(b), err))
+			r.discard(len(b))
+			return nil, io.EOF
+		}
+		h, err := parseHeader(b)
+		if err != nil {
+			return nil, err
+		}
+		if h.MagicNumber != MagicNumber {
+			r.resync()
+			r.repair(off, fmt.Errorf("skipped %d bytes before next magic number", r.off-off))
+			continue
+		}
+		if err := h.validate(); err != nil {
+			if !h.SegmentType.known() {
+				r.discard(13 + int(h.SegmentSize))
+				r.repair(off, fmt.Errorf("skipped segment: %w", err))
+				continue
+			}
+			r.repair(off, err)
+		}
+		r.discard(13)
+		s, err := r.readPayload(h, off)
+		if err != nil {
+			r.repair(off, fmt.Errorf("skipped truncated %s segment: %w", h.SegmentType, err))
+			return nil, io.EOF
+		}
+		return s, nil
+	}
+}
+
+// read reads a segment without recovering, consuming exactly its bytes.
+func (r *SegmentReader) read() (*Segment, error) {
+	off := r.off
+	var b [13]byte
+	n, err := io.ReadFull(r.r, b[:])
+	r.off += int64(n)
+	if err != nil {
+		return nil, err
+	}
+	h, err := parseHeader(b[:])
+	if err != nil {
+		return nil, err
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	return r.readPayload(h, off)
+}
+
+// readPayload reads the payload of the segment with the header at off.
+func (r *SegmentReader) readPayload(h *SegmentHeader, off int64) (*Segment, error) {
+	s := &Segment{SegmentHeader: *h, Offset: off, Data: make([]byte, h.SegmentSize)}
+	n, err := io.ReadFull(r.r, s.Data)
+	r.off += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseHeader(b []byte) (*SegmentHeader, error) {
+	var h SegmentHeader
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// resync discards bytes up to the next "PG" magic number that is
+// followed by a recognized segment type.
+func (r *SegmentReader) resync() {
+	r.discard(1)
+	for {
+		b, _ := r.br.Peek(13)
+		if len(b) < 13 {
+			r.discard(len(b))
+			return
+		}
+		if b[0] == 'P' && b[1] == 'G' && SegmentType(b[10]).known() {
+			return
+		}
+		r.discard(1)
+	}
+}
+
+func (r *SegmentReader) discard(n int) {
+	d, _ := r.br.Discard(n)
+	r.off += int64(d)
+}
+
+// SegmentWriter writes the individual segments of a stream.
+type SegmentWriter struct {
+	w io.Writer
+}
+
+func NewSegmentWriter(w io.Writer) *SegmentWriter {
+	return &SegmentWriter{w}
+}
+
+// Write writes the segment header and payload verbatim, without
+// validating them, so that damaged segments are preserved.
+func (w *SegmentWriter) Write(s *Segment) error {
+	if int(s.SegmentSize) != len(s.Data) {
+		return fmt.Errorf("segment size %d not consistent with payload length %d", s.SegmentSize, len(s.Data))
+	}
+	if err := binary.Write(w.w, binary.BigEndian, &s.SegmentHeader); err != nil {
+		return err
+	}
+	_, err := w.w.Write(s.Data)
+	return err
+}
@@ -0,0 +1,121 @@
+package pgs
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+)
+
+// Subtitle is a rendered image shown on screen from In until Out.
+type Subtitle struct {
+	In, Out time.Duration
+	X, Y    int // Offset from the top left pixel of the screen
+	Image   image.Image
+}
+
+// Subtitles returns a subtitle for each event, with its images drawn
+// in the colors of their palettes and cropped to the visible pixels.
+// Events with no visible pixels, such as at the end of a fade, are
+// skipped and consecutive events that look the same are merged. Out is
+// zero for a subtitle still shown at the end of the stream.
+func Subtitles(events []Event) []Subtitle {
+	var subs []Subtitle
+	for i := range events {
+		ev := &events[i]
+		var r image.Rectangle
+		for _, img := range ev.Images {
+			r = r.Union(img.Rect())
+		}
+		screen := image.NewRGBA(r)
+		for _, img := range ev.Images {
+			draw.Draw(screen, img.Rect(), img.Image, img.Image.Rect.Min, draw.Over)
+		}
+		img := crop(screen)
+		if img == nil {
+			continue
+		}
+		if n := len(subs); n != 0 && subs[n-1].Out == ev.Start && sameImage(subs[n-1].Image.(*image.RGBA), img) {
+			subs[n-1].Out = ev.End
+			continue
+		}
+		subs = append(subs, Subtitle{
+			In:    ev.Start,
+			Out:   ev.End,
+			X:     img.Rect.Min.X,
+			Y:     img.Rect.Min.Y,
+			Image: img,
+		})
+	}
+	return subs
+}
+
+// crop copies the smallest region of the image that contains all
+// visible pixels, or returns nil if there are none.
+func crop(img *image.RGBA) *image.RGBA {
+	var r image.Rectangle
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.Pix[img.PixOffset(x, y)+3] != 0 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if r.Empty() {
+		return nil
+	}
+	dst := image.NewRGBA(r)
+	draw.Draw(dst, r, img, r.Min, draw.Src)
+	return dst
+}
+
+func sameImage(a, b *image.RGBA) bool {
+	if a.Rect != b.Rect {
+		return false
+	}
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		i, j := a.PixOffset(a.Rect.Min.X, y), b.PixOffset(b.Rect.Min.X, y)
+		n := a.Rect.Dx() * 4
+		if !bytes.Equal(a.Pix[i:i+n], b.Pix[j:j+n]) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewStream compiles subtitles into a stream with CompileEvents.
+// Consecutive subtitles with the same In and Out are shown together,
+// each as its own image. A subtitle with a zero Out is shown until the
+// next subtitle, without being cleared. The screen size and frame rate
+// are those of pc. The images shown together are quantized to at most
+// 255 colors, with entry 0 for transparent pixels, and converted to
+// YCbCr in the color space.
+func NewStream(subs []Subtitle, pc PresentationComposition, cs ColorSpace) ([]DisplaySet, error) {
+	cs = cs.Resolve(pc.Height)
+	var events []Event
+	for i := 0; i < len(subs); {
+		j := i + 1
+		for j < len(subs) && subs[j].In == subs[i].In && subs[j].Out == subs[i].Out {
+			j++
+		}
+		imgs := make([]image.Image, j-i)
+		for k, sub := range subs[i:j] {
+			imgs[k] = sub.Image
+		}
+		p := append(color.Palette{color.Transparent}, quantize(imgs, 255)...)
+		palette, err := NewPalette(0, 0, p, cs)
+		if err != nil {
+			return nil, fmt.Errorf("subtitle %d: %w", i+1, err)
+		}
+		ev := Event{Start: subs[i].In, End: subs[i].Out, Palette: palette.Entries}
+		for _, sub := range subs[i:j] {
+			ev.Images = append(ev.Images, EventImage{X: sub.X, Y: sub.Y, Image: Paletted(sub.Image, p)})
+		}
+		events = append(events, ev)
+		i = j
+	}
+	return CompileEvents(events, pc)
+}
@@ -0,0 +1,44 @@
+package pgs
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestSubtitles(t *testing.T) {
+	// A 4x2 image with the right half in c
+	half := func(c color.Color) EventImage {
+		img := image.NewPaletted(image.Rect(0, 0, 4, 2), color.Palette{color.Transparent, c})
+		for y := 0; y < 2; y++ {
+			img.SetColorIndex(2, y, 1)
+			img.SetColorIndex(3, y, 1)
+		}
+		return EventImage{X: 10, Y: 20, Image: img}
+	}
+	white, gray := color.NRGBA{255, 255, 255, 255}, color.NRGBA{255, 255, 255, 0x80}
+	sec := time.Second
+	events := []Event{
+		{Start: 1 * sec, End: 2 * sec, Images: []EventImage{half(white)}},
+		// Looks the same, as when only an unused entry changes
+		{Start: 2 * sec, End: 3 * sec, Images: []EventImage{half(white)}},
+		{Start: 3 * sec, End: 4 * sec, Images: []EventImage{half(gray)}},
+		// Faded out
+		{Start: 4 * sec, End: 5 * sec, Images: []EventImage{half(color.Transparent)}},
+		{Start: 6 * sec, Images: []EventImage{half(white)}},
+	}
+	want := []struct{ in, out time.Duration }{{1 * sec, 3 * sec}, {3 * sec, 4 * sec}, {6 * sec, 0}}
+
+	subs := Subtitles(events)
+	if len(subs) != len(want) {
+		t.Fatalf("got %d subtitles, want %d", len(subs), len(want))
+	}
+	for i, sub := range subs {
+		rect := sub.Image.Bounds().Sub(sub.Image.Bounds().Min).Add(image.Pt(sub.X, sub.Y))
+		if sub.In != want[i].in || sub.Out != want[i].out || rect != image.Rect(12, 20, 14, 22) {
+			t.Errorf("subtitle %d: got %s to %s at %v, want %s to %s at %v",
+				i, sub.In, sub.Out, rect, want[i].in, want[i].out, image.Rect(12, 20, 14, 22))
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package pgs
 
-import "fmt"
+import (
+	"fmt"
+	"image"
+)
 
-func (h *header) validate() error {
-	if h.MagicNumber != 0x5047 {
+func (h *SegmentHeader) validate() error {
+	if h.MagicNumber != MagicNumber {
 		return fmt.Errorf(`magic number not "PG" 0x5047: %x`, h.MagicNumber)
 	}
 	switch h.SegmentType {
@@ -17,7 +20,7 @@ func (h *header) validate() error {
 			return fmt.Errorf("nonzero segment size: %d bytes", h.SegmentSize)
 		}
 	default:
-		return fmt.Errorf("unrecognized segment type: 0x%x", h.SegmentType)
+		return fmt.Errorf("unrecognized segment type: %s", h.SegmentType)
 	}
 	if h.DecodingTime > h.PresentationTime {
 		return fmt.Errorf("decoding time %s (0x%x) after presentation time %s (0x%x)",
@@ -64,17 +67,178 @@ func (p *Palette) validate(segmentSize uint16) error {
 	return nil
 }
 
-func (ods *ods) validate(segmentSize uint16) error {
-	// Object data length may overflow segment size
-	l := ods.ObjectDataLength.Int()
-	if l < 0xffff-11 && l != int(segmentSize)-7 {
-		return fmt.Errorf("segment size %d not consistent with object data length %d", segmentSize, l)
+func (ods *ods) validate() error {
+	if ods.SequenceFlag&^(firstInSequence|lastInSequence) != 0 {
+		return fmt.Errorf("unrecognized flag: 0x%x", ods.SequenceFlag)
 	}
+	return nil
+}
+
+func (first *odsFirst) validate(segmentSize uint16, last bool) error {
+	// Object data length overflows segment size when fragmented
+	l := first.ObjectDataLength.Int()
 	if l < 4 {
 		return fmt.Errorf("data length excludes width and height")
 	}
-	if ods.SequenceFlag&^(firstInSequence|lastInSequence) != 0 {
-		return fmt.Errorf("unrecognized flag: 0x%x", ods.SequenceFlag)
+	if last && l != int(segmentSize)-7 || !last && l <= int(segmentSize)-7 {
+		return fmt.Errorf("segment size %d not consistent with object data length %d", segmentSize, l)
 	}
 	return nil
 }
+
+// Severity is the severity of a finding in a stream.
+type Severity uint8
+
+const (
+	Warning Severity = iota // Unusual, but decodable
+	Error                   // Violates the format
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", uint8(s))
+}
+
+// Finding is a problem found when validating a stream.
+type Finding struct {
+	Index    int // Index of the display set
+	Severity Severity
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("display set %d: %s: %s", f.Index, f.Severity, f.Message)
+}
+
+// Limits of the decoder model in the Blu-ray specification
+const (
+	maxObjectBuffer      = 4 << 20 // Decoded object buffer in bytes
+	maxObjects           = 64      // Objects defined per epoch
+	maxPalettes          = 8       // Palettes defined per epoch
+	maxWindows           = 2       // Windows defined per epoch
+	maxCompositionObject = 2       // Objects per composition
+)
+
+// ValidateStream checks the consistency of a stream across display
+// sets: that it starts with an Epoch Start, composition numbers and
+// presentation times increase, definitions are versioned within each
+// epoch, referenced definitions exist, composition objects are within
+// the video and their windows, and the limits of the decoder model are
+// held.
+func ValidateStream(stream []DisplaySet) []Finding {
+	var findings []Finding
+	report := func(i int, sev Severity, format string, args ...interface{}) {
+		findings = append(findings, Finding{i, sev, fmt.Sprintf(format, args...)})
+	}
+
+	e := NewEpoch()
+	for i := range stream {
+		ds := &stream[i]
+		if i == 0 {
+			if ds.CompositionState != EpochStart {
+				report(i, Error, "first display set is not an Epoch Start")
+			}
+		} else {
+			prev := &stream[i-1]
+			if d := ds.CompositionNumber - prev.CompositionNumber; d == 0 || d >= 0x8000 {
+				report(i, Error, "composition number %d does not increase from %d",
+					ds.CompositionNumber, prev.CompositionNumber)
+			}
+			if ds.PresentationTime < prev.PresentationTime {
+				report(i, Error, "presentation time %s before previous %s",
+					ds.PresentationTime, prev.PresentationTime)
+			}
+			if ds.CompositionState != EpochStart &&
+				(ds.Width != prev.Width || ds.Height != prev.Height) {
+				report(i, Warning, "video dimensions %dx%d change from %dx%d within epoch",
+					ds.Width, ds.Height, prev.Width, prev.Height)
+			}
+		}
+
+		if ds.CompositionState != EpochStart {
+			for _, p := range ds.Palettes {
+				if prev, ok := e.Palettes[p.ID]; ok {
+					validateVersion(ds, i, "palette", uint16(p.ID), prev.Version, p.Version, report)
+				}
+			}
+			for _, obj := range ds.Objects {
+				if prev, ok := e.Objects[obj.ID]; ok {
+					validateVersion(ds, i, "object", obj.ID, prev.Version, obj.Version, report)
+				}
+			}
+		}
+
+		if err := e.Apply(ds); err != nil {
+			report(i, Error, "%v", err)
+		}
+
+		if len(e.Windows) > maxWindows {
+			report(i, Error, "%d windows defined in epoch, at most %d allowed", len(e.Windows), maxWindows)
+		}
+		if len(e.Palettes) > maxPalettes {
+			report(i, Error, "%d palettes defined in epoch, at most %d allowed", len(e.Palettes), maxPalettes)
+		}
+		if len(e.Objects) > maxObjects {
+			report(i, Error, "%d objects defined in epoch, at most %d allowed", len(e.Objects), maxObjects)
+		}
+		size := 0
+		for _, obj := range e.Objects {
+			size += int(obj.Width) * int(obj.Height)
+		}
+		if size > maxObjectBuffer {
+			report(i, Error, "objects in epoch use %d bytes, object buffer holds %d", size, maxObjectBuffer)
+		}
+		if n := len(ds.CompositionObjects); n > maxCompositionObject {
+			report(i, Error, "%d composition objects, at most %d allowed", n, maxCompositionObject)
+		}
+
+		screen := image.Rect(0, 0, int(ds.Width), int(ds.Height))
+		for _, w := range ds.Windows {
+			if !w.Rect().In(screen) {
+				report(i, Error, "window %d at %v outside of video %v", w.ID, w.Rect(), screen)
+			}
+		}
+		for _, co := range ds.CompositionObjects {
+			obj, ok1 := e.Objects[co.ObjectID]
+			w, ok2 := e.Windows[co.WindowID]
+			if !ok1 || !ok2 {
+				continue // Reported by Apply
+			}
+			r := image.Rect(0, 0, int(obj.Width), int(obj.Height))
+			if co.Crop != nil {
+				crop := image.Rect(int(co.Crop.X), int(co.Crop.Y),
+					int(co.Crop.X)+int(co.Crop.Width), int(co.Crop.Y)+int(co.Crop.Height))
+				if !crop.In(r) {
+					report(i, Error, "object %d crop %v outside of object %v", co.ObjectID, crop, r)
+				}
+				r = crop.Sub(crop.Min)
+			}
+			r = r.Add(image.Pt(int(co.X), int(co.Y)))
+			if !r.In(screen) {
+				report(i, Error, "object %d at %v outside of video %v", co.ObjectID, r, screen)
+			}
+			if !r.In(w.Rect()) {
+				report(i, Error, "object %d at %v outside of window %d at %v", co.ObjectID, r, w.ID, w.Rect())
+			}
+		}
+	}
+	return findings
+}
+
+// validateVersion checks that a redefinition within an epoch increments
+// the version. Acquisition Points may repeat a definition unchanged.
+func validateVersion(ds *DisplaySet, i int, kind string, id uint16, prev, version uint8,
+	report func(int, Severity, string, ...interface{})) {
+	if version != prev {
+		if d := version - prev; d >= 0x80 {
+			report(i, Error, "%s %d version %d decreases from %d", kind, id, version, prev)
+		}
+	} else if ds.CompositionState != AcquisitionPoint {
+		report(i, Error, "%s %d redefined without incrementing version %d", kind, id, version)
+	}
+}
@@ -0,0 +1,64 @@
+package pgs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateStream(t *testing.T) {
+	newStream := func() []DisplaySet {
+		pc := PresentationComposition{Width: 1920, Height: 1080, FrameRate: FrameRate23976}
+		stream := []DisplaySet{
+			{PresentationTime: 1 * time.Second, PresentationComposition: pc},
+			{PresentationTime: 2 * time.Second, PresentationComposition: pc},
+			{PresentationTime: 3 * time.Second, PresentationComposition: pc},
+		}
+		stream[0].CompositionState = EpochStart
+		stream[0].CompositionObjects = []CompositionObject{{X: 100, Y: 900}}
+		stream[0].Windows = []Window{{X: 100, Y: 900, Width: 200, Height: 100}}
+		stream[0].Palettes = Palettes{{}}
+		stream[0].Objects = []Object{{Image: Image{Width: 200, Height: 100}}}
+		stream[1].CompositionNumber = 1
+		stream[1].CompositionObjects = stream[0].CompositionObjects
+		stream[1].Objects = []Object{{Version: 1, Image: Image{Width: 200, Height: 100}}}
+		stream[2].CompositionNumber = 2
+		return stream
+	}
+	tests := []struct {
+		name   string
+		modify func(stream []DisplaySet)
+		want   []string
+	}{
+		{"valid", func([]DisplaySet) {}, nil},
+		{"start", func(s []DisplaySet) { s[0].CompositionState = AcquisitionPoint },
+			[]string{"display set 0: error: first display set is not an Epoch Start"}},
+		{"number", func(s []DisplaySet) { s[2].CompositionNumber = 1 },
+			[]string{"display set 2: error: composition number 1 does not increase from 1"}},
+		{"time", func(s []DisplaySet) { s[2].PresentationTime = 0 },
+			[]string{"display set 2: error: presentation time 0s before previous 2s"}},
+		{"version", func(s []DisplaySet) { s[1].Objects[0].Version = 0 },
+			[]string{"display set 1: error: object 0 redefined without incrementing version 0"}},
+		{"reference", func(s []DisplaySet) { s[1].PaletteID = 1 },
+			[]string{"display set 1: error: palette 1 not defined"}},
+		{"window", func(s []DisplaySet) { s[1].CompositionObjects = []CompositionObject{{X: 150, Y: 900}} },
+			[]string{"display set 1: error: object 0 at (150,900)-(350,1000) outside of window 0 at (100,900)-(300,1000)"}},
+		{"video", func(s []DisplaySet) { s[0].Windows[0].Y = 1000 },
+			[]string{
+				"display set 0: error: window 0 at (100,1000)-(300,1100) outside of video (0,0)-(1920,1080)",
+				"display set 0: error: object 0 at (100,900)-(300,1000) outside of window 0 at (100,1000)-(300,1100)",
+				"display set 1: error: object 0 at (100,900)-(300,1000) outside of window 0 at (100,1000)-(300,1100)",
+			}},
+	}
+	for _, tt := range tests {
+		stream := newStream()
+		tt.modify(stream)
+		var got []string
+		for _, f := range ValidateStream(stream) {
+			got = append(got, f.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: findings:\n%s\nwant:\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
@@ -1,17 +1,18 @@
 package pgs
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 )
 
 type Writer struct {
-	w io.Writer
+	sw *SegmentWriter
 }
 
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w}
+	return &Writer{NewSegmentWriter(w)}
 }
 
 func (w *Writer) WriteAll(stream []DisplaySet) error {
@@ -24,10 +25,16 @@ func (w *Writer) WriteAll(stream []DisplaySet) error {
 }
 
 func (w *Writer) Write(ds *DisplaySet) error {
-	h := header{
-		MagicNumber:      0x5047,
-		PresentationTime: fromDuration(ds.PresentationTime),
-		DecodingTime:     fromDuration(ds.DecodingTime),
+	if ds.PresentationTime < 0 || ds.PresentationTime > MaxTime {
+		return fmt.Errorf("presentation time out of range: %s", ds.PresentationTime)
+	}
+	if ds.DecodingTime < 0 || ds.DecodingTime > MaxTime {
+		return fmt.Errorf("decoding time out of range: %s", ds.DecodingTime)
+	}
+	h := SegmentHeader{
+		MagicNumber:      MagicNumber,
+		PresentationTime: NewTimestamp(ds.PresentationTime),
+		DecodingTime:     NewTimestamp(ds.DecodingTime),
 	}
 	if err := w.writePresentationComposition(h, &ds.PresentationComposition); err != nil {
 		return fmt.Errorf("presentation composition segment: %w", err)
@@ -37,40 +44,38 @@ func (w *Writer) Write(ds *DisplaySet) error {
 			return fmt.Errorf("window definition segment: %w", err)
 		}
 	}
-	if ds.Palette != nil {
-		if err := w.writePalette(h, ds.Palette); err != nil {
+	for i := range ds.Palettes {
+		if err := w.writePalette(h, &ds.Palettes[i]); err != nil {
 			return fmt.Errorf("palette definition segment: %w", err)
 		}
 	}
-	if ds.Object != nil {
-		if err := w.writeObject(h, ds.Object); err != nil {
-			return fmt.Errorf("palette definition segment: %w", err)
+	for i := range ds.Objects {
+		if err := w.writeObject(h, &ds.Objects[i]); err != nil {
+			return fmt.Errorf("object definition segment: %w", err)
 		}
 	}
 	h.SegmentType = ENDType
-	return w.writeHeader(&h)
+	return w.writeSegment(h, nil)
 }
 
-func (w *Writer) writeHeader(h *header) error {
+// writeSegment validates the header, with the size of the payload, and
+// writes the segment.
+func (w *Writer) writeSegment(h SegmentHeader, data []byte) error {
+	if len(data) > 0xffff {
+		return fmt.Errorf("segment size overflow: %d", len(data))
+	}
+	h.SegmentSize = uint16(len(data))
 	if err := h.validate(); err != nil {
 		return err
 	}
-	return binary.Write(w.w, binary.BigEndian, h)
+	return w.sw.Write(&Segment{SegmentHeader: h, Data: data})
 }
 
-func (w *Writer) writePresentationComposition(h header, pc *PresentationComposition) error {
-	if len(pc.Objects) > 0xff {
-		return fmt.Errorf("object count overflow: %d", len(pc.Objects))
-	}
-	size := uint16(11)
-	for i := range pc.Objects {
-		if pc.Objects[i].Crop != nil {
-			size += 8
-		}
-		size += 8
+func (w *Writer) writePresentationComposition(h SegmentHeader, pc *PresentationComposition) error {
+	if len(pc.CompositionObjects) > 0xff {
+		return fmt.Errorf("object count overflow: %d", len(pc.CompositionObjects))
 	}
 	h.SegmentType = PCSType
-	h.SegmentSize = size
 
 	var puf paletteUpdateFlag
 	if pc.PaletteUpdate {
@@ -84,19 +89,15 @@ func (w *Writer) writePresentationComposition(h header, pc *PresentationComposit
 		CompositionState:  pc.CompositionState,
 		PaletteUpdateFlag: puf,
 		PaletteID:         pc.PaletteID,
-		ObjectCount:       uint8(len(pc.Objects)),
+		ObjectCount:       uint8(len(pc.CompositionObjects)),
 	}
 	if err := pcs.validate(); err != nil {
 		return err
 	}
 
-	if err := w.writeHeader(&h); err != nil {
-		return err
-	}
-	if err := binary.Write(w.w, binary.BigEndian, pcs); err != nil {
-		return err
-	}
-	for i, obj := range pc.Objects {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, pcs)
+	for i, obj := range pc.CompositionObjects {
 		var cropped objectCroppedFlag
 		if obj.Crop != nil {
 			cropped |= croppedForce
@@ -109,97 +110,98 @@ func (w *Writer) writePresentationComposition(h header, pc *PresentationComposit
 			Y:             obj.Y,
 		}
 		if err := o.validate(); err != nil {
-			return fmt.Errorf("composition object %d/%d: %w", i+1, len(pc.Objects), err)
-		}
-		if err := binary.Write(w.w, binary.BigEndian, &o); err != nil {
-			return err
+			return fmt.Errorf("composition object %d/%d: %w", i+1, len(pc.CompositionObjects), err)
 		}
+		binary.Write(&buf, binary.BigEndian, &o)
 		if obj.Crop != nil {
-			if err := binary.Write(w.w, binary.BigEndian, &obj.Crop); err != nil {
-				return err
-			}
+			binary.Write(&buf, binary.BigEndian, obj.Crop)
 		}
 	}
-	return nil
+	return w.writeSegment(h, buf.Bytes())
 }
 
-func (w *Writer) writeWindows(h header, ws []Window) error {
+func (w *Writer) writeWindows(h SegmentHeader, ws []Window) error {
 	if len(ws) > 0xff {
 		return fmt.Errorf("window count overflow: %d", len(ws))
 	}
 	h.SegmentType = WDSType
-	h.SegmentSize = uint16(len(ws))*9 + 1
 	wds := &wds{WindowCount: uint8(len(ws))}
-	if err := wds.validate(h.SegmentSize); err != nil {
+	if err := wds.validate(uint16(len(ws))*9 + 1); err != nil {
 		return err
 	}
 
-	if err := w.writeHeader(&h); err != nil {
-		return err
-	}
-	if err := binary.Write(w.w, binary.BigEndian, wds); err != nil {
-		return err
-	}
-	for i := range ws {
-		if err := binary.Write(w.w, binary.BigEndian, &ws[i]); err != nil {
-			return err
-		}
-	}
-	return nil
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, wds)
+	binary.Write(&buf, binary.BigEndian, ws)
+	return w.writeSegment(h, buf.Bytes())
 }
 
-func (w *Writer) writePalette(h header, p *Palette) error {
+func (w *Writer) writePalette(h SegmentHeader, p *Palette) error {
+	if len(p.Entries) > 0x100 {
+		return fmt.Errorf("palette entry count overflow: %d", len(p.Entries))
+	}
 	h.SegmentType = PDSType
-	h.SegmentSize = uint16(len(p.Entries)*5 + 2)
 	pds := &pds{p.ID, p.Version}
-	if err := p.validate(h.SegmentSize); err != nil {
+	if err := p.validate(uint16(len(p.Entries)*5 + 2)); err != nil {
 		return err
 	}
 
-	if err := w.writeHeader(&h); err != nil {
-		return err
-	}
-	if err := binary.Write(w.w, binary.BigEndian, pds); err != nil {
-		return err
-	}
-	return binary.Write(w.w, binary.BigEndian, p.Entries)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, pds)
+	binary.Write(&buf, binary.BigEndian, p.Entries)
+	return w.writeSegment(h, buf.Bytes())
 }
 
-func (w *Writer) writeObject(h header, obj *Object) error {
-	if len(obj.Data) > 0xffffff-4 {
-		return fmt.Errorf("object data length overflow: %d", len(obj.Data))
-	}
-	h.SegmentType = ODSType
-	h.SegmentSize = uint16(len(obj.Data) + 11)
-
-	var seq sequenceFlag
-	if obj.First {
-		seq |= firstInSequence
-	}
-	if obj.Last {
-		seq |= lastInSequence
-	}
+// writeObject writes an object as a sequence of ODS segments, splitting
+// the data into fragments when it does not fit in a single segment.
+func (w *Writer) writeObject(h SegmentHeader, obj *Object) error {
 	l, err := uint24FromInt(len(obj.Data) + 4)
 	if err != nil {
-		return err
+		return fmt.Errorf("object data length overflow: %w", err)
 	}
-	ods := &ods{
-		ObjectID:         obj.ID,
-		ObjectVersion:    obj.Version,
-		SequenceFlag:     seq,
+	first := &odsFirst{
 		ObjectDataLength: l,
 		Width:            obj.Width,
 		Height:           obj.Height,
 	}
-	if err := ods.validate(h.SegmentSize); err != nil {
-		return err
-	}
+	h.SegmentType = ODSType
 
-	if err := w.writeHeader(&h); err != nil {
-		return err
-	}
-	if err := binary.Write(w.w, binary.BigEndian, ods); err != nil {
-		return err
+	data := obj.Data
+	for seq := firstInSequence; ; seq = 0 {
+		max := maxNextFragment
+		if seq&firstInSequence != 0 {
+			max = maxFirstFragment
+		}
+		n := len(data)
+		if n <= max {
+			seq |= lastInSequence
+		} else {
+			n = max
+		}
+		ods := &ods{
+			ObjectID:      obj.ID,
+			ObjectVersion: obj.Version,
+			SequenceFlag:  seq,
+		}
+		if err := ods.validate(); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, ods)
+		if seq&firstInSequence != 0 {
+			if err := first.validate(uint16(n+11), seq&lastInSequence != 0); err != nil {
+				return err
+			}
+			binary.Write(&buf, binary.BigEndian, first)
+		}
+		buf.Write(data[:n])
+		if err := w.writeSegment(h, buf.Bytes()); err != nil {
+			return err
+		}
+		data = data[n:]
+		if seq&lastInSequence != 0 {
+			return nil
+		}
 	}
-	return binary.Write(w.w, binary.BigEndian, obj.Data)
 }
@@ -0,0 +1,211 @@
+// Package pgsjson serializes PGS streams as JSON, so that they can be
+// edited by other tools.
+//
+// A stream is an array of display sets. Presentation and decoding times
+// are in ticks of the 90 kHz clock and the other fields have the names
+// of the pgs package. Object image data is either inline, as base64
+// run-length encoded data in Data, or an external PNG file named in
+// File.
+package pgsjson
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+type displaySet struct {
+	PresentationTime   pgs.Timestamp
+	DecodingTime       pgs.Timestamp
+	Width, Height      uint16
+	FrameRate          pgs.FrameRate
+	CompositionNumber  uint16
+	CompositionState   pgs.CompositionState
+	PaletteUpdate      bool
+	PaletteID          uint8
+	CompositionObjects []pgs.CompositionObject
+	Windows            []pgs.Window  `json:",omitempty"`
+	Palettes           []pgs.Palette `json:",omitempty"`
+	Objects            []object      `json:",omitempty"`
+}
+
+type object struct {
+	ID            uint16
+	Version       uint8
+	Width, Height uint16
+	Data          []byte `json:",omitempty"` // Run-length encoded image data
+	File          string `json:",omitempty"` // PNG file relative to the image directory
+}
+
+// Encode writes the stream as JSON with the image data of objects
+// inline. Decoding it gives back the exact stream.
+func Encode(w io.Writer, stream []pgs.DisplaySet) error {
+	doc := make([]displaySet, len(stream))
+	for i := range stream {
+		doc[i] = newDisplaySet(&stream[i])
+		for _, obj := range stream[i].Objects {
+			doc[i].Objects = append(doc[i].Objects, object{
+				ID:      obj.ID,
+				Version: obj.Version,
+				Width:   obj.Width,
+				Height:  obj.Height,
+				Data:    obj.Data,
+			})
+		}
+	}
+	return encode(w, doc)
+}
+
+// EncodePNG writes the stream as JSON with each object image written
+// as a paletted PNG file in dir. The color index of each pixel is its
+// palette entry ID and the colors are converted from the palette of the
+// composition in the color space. Images are run-length encoded again
+// when decoded, so the pixels are preserved, but the encoding may
+// differ from the original.
+func EncodePNG(w io.Writer, stream []pgs.DisplaySet, dir string, cs pgs.ColorSpace) error {
+	doc := make([]displaySet, len(stream))
+	e := pgs.NewEpoch()
+	for i := range stream {
+		ds := &stream[i]
+		doc[i] = newDisplaySet(ds)
+		if err := e.Apply(ds); err != nil {
+			return fmt.Errorf("display set %d: %w", i, err)
+		}
+		p, ok := e.Palettes[ds.PaletteID]
+		if !ok {
+			p = pgs.Palette{ID: ds.PaletteID}
+		}
+		for _, obj := range ds.Objects {
+			img, err := obj.Image.Convert(&p, cs.Resolve(ds.Height))
+			if err != nil {
+				return fmt.Errorf("display set %d: object %d: %w", i, obj.ID, err)
+			}
+			name := fmt.Sprintf("%04d_%d_%d.png", i, obj.ID, obj.Version)
+			if err := writePNG(filepath.Join(dir, name), img); err != nil {
+				return err
+			}
+			doc[i].Objects = append(doc[i].Objects, object{
+				ID:      obj.ID,
+				Version: obj.Version,
+				Width:   obj.Width,
+				Height:  obj.Height,
+				File:    name,
+			})
+		}
+	}
+	return encode(w, doc)
+}
+
+func newDisplaySet(ds *pgs.DisplaySet) displaySet {
+	return displaySet{
+		PresentationTime:   pgs.NewTimestamp(ds.PresentationTime),
+		DecodingTime:       pgs.NewTimestamp(ds.DecodingTime),
+		Width:              ds.Width,
+		Height:             ds.Height,
+		FrameRate:          ds.FrameRate,
+		CompositionNumber:  ds.CompositionNumber,
+		CompositionState:   ds.CompositionState,
+		PaletteUpdate:      ds.PaletteUpdate,
+		PaletteID:          ds.PaletteID,
+		CompositionObjects: ds.CompositionObjects,
+		Windows:            ds.Windows,
+		Palettes:           ds.Palettes,
+	}
+}
+
+func encode(w io.Writer, doc []displaySet) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(doc)
+}
+
+// Decode reads a stream from JSON. PNG files referenced by objects are
+// read relative to dir and must be paletted.
+func Decode(r io.Reader, dir string) ([]pgs.DisplaySet, error) {
+	var doc []displaySet
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	stream := make([]pgs.DisplaySet, len(doc))
+	for i, d := range doc {
+		ds := &stream[i]
+		ds.PresentationTime = d.PresentationTime.Duration()
+		ds.DecodingTime = d.DecodingTime.Duration()
+		ds.PresentationComposition = pgs.PresentationComposition{
+			Width:              d.Width,
+			Height:             d.Height,
+			FrameRate:          d.FrameRate,
+			CompositionNumber:  d.CompositionNumber,
+			CompositionState:   d.CompositionState,
+			PaletteUpdate:      d.PaletteUpdate,
+			PaletteID:          d.PaletteID,
+			CompositionObjects: d.CompositionObjects,
+		}
+		ds.Windows = d.Windows
+		ds.Palettes = d.Palettes
+		for _, o := range d.Objects {
+			obj, err := o.decode(dir)
+			if err != nil {
+				return nil, fmt.Errorf("display set %d: object %d: %w", i, o.ID, err)
+			}
+			ds.Objects = append(ds.Objects, *obj)
+		}
+	}
+	return stream, nil
+}
+
+func (o *object) decode(dir string) (*pgs.Object, error) {
+	if o.File == "" {
+		return &pgs.Object{
+			ID:      o.ID,
+			Version: o.Version,
+			Image:   pgs.Image{Width: o.Width, Height: o.Height, Data: o.Data},
+		}, nil
+	}
+	if o.Data != nil {
+		return nil, errors.New("both Data and File given")
+	}
+	img, err := readPNG(filepath.Join(dir, o.File))
+	if err != nil {
+		return nil, err
+	}
+	if size := img.Bounds().Size(); size.X != int(o.Width) || size.Y != int(o.Height) {
+		return nil, fmt.Errorf("%s: image is %dx%d, object is %dx%d",
+			o.File, size.X, size.Y, o.Width, o.Height)
+	}
+	obj, err := pgs.NewObject(o.ID, o.Version, img, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o.File, err)
+	}
+	return obj, nil
+}
+
+func writePNG(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readPNG(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
@@ -0,0 +1,97 @@
+package pgsjson
+
+import (
+	"bytes"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+func TestRoundTrip(t *testing.T) {
+	stream := []pgs.DisplaySet{{
+		PresentationTime: 1 * time.Second,
+		DecodingTime:     900 * time.Millisecond,
+		PresentationComposition: pgs.PresentationComposition{
+			Width:            1920,
+			Height:           1080,
+			FrameRate:        pgs.FrameRate23976,
+			CompositionState: pgs.EpochStart,
+			CompositionObjects: []pgs.CompositionObject{{
+				ObjectID: 1,
+				X:        10,
+				Y:        20,
+				Crop:     &pgs.CompositionObjectCrop{X: 1, Y: 0, Width: 2, Height: 2},
+			}},
+		},
+		Windows: []pgs.Window{{X: 10, Y: 20, Width: 3, Height: 2}},
+		Palettes: pgs.Palettes{{Entries: []pgs.PaletteEntry{
+			{ID: 3, NYCbCrA: color.NYCbCrA{YCbCr: color.YCbCr{Y: 235, Cb: 128, Cr: 128}, A: 255}},
+		}}},
+		// Long form of a short run, which is re-encoded from PNG
+		Objects: []pgs.Object{{ID: 1, Image: pgs.Image{Width: 3, Height: 2,
+			Data: []byte{0, 0xc0, 3, 3, 0, 0, 3, 0, 2, 0, 0}}}},
+	}, {
+		PresentationTime: 3 * time.Second,
+		DecodingTime:     3 * time.Second,
+		PresentationComposition: pgs.PresentationComposition{
+			Width:             1920,
+			Height:            1080,
+			FrameRate:         pgs.FrameRate23976,
+			CompositionNumber: 1,
+		},
+	}}
+	var want bytes.Buffer
+	if err := pgs.NewWriter(&want).WriteAll(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc bytes.Buffer
+	if err := Encode(&doc, stream); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(&doc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := pgs.NewWriter(&buf).WriteAll(got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want.Bytes()) {
+		t.Errorf("inline round trip not identical:\n%+v\nwant:\n%+v", got, stream)
+	}
+
+	dir, err := ioutil.TempDir("", "pgsjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc.Reset()
+	if err := EncodePNG(&doc, stream, dir, pgs.ColorSpace{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = Decode(&doc, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantData := []byte{0, 0x83, 3, 0, 0, 3, 0, 2, 0, 0}
+	if data := got[0].Objects[0].Data; !bytes.Equal(data, wantData) {
+		t.Errorf("PNG object data %v, want %v", data, wantData)
+	}
+	got[0].Objects = stream[0].Objects
+	if !reflect.DeepEqual(got, stream) {
+		t.Errorf("PNG round trip:\n%+v\nwant:\n%+v", got, stream)
+	}
+
+	// Without the palette the composition references
+	undefined := append([]pgs.DisplaySet{}, stream...)
+	undefined[0].Palettes = nil
+	if err := EncodePNG(&doc, undefined, dir, pgs.ColorSpace{}); err == nil {
+		t.Error("encoded PNGs with an undefined palette")
+	}
+}
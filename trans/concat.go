@@ -0,0 +1,84 @@
+package trans
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Concat joins stream b, shifted by offset, to the end of stream a.
+// Stream b must start with an Epoch Start no earlier than the last
+// display set of a. Composition numbers and versions are renumbered.
+func Concat(a, b []pgs.DisplaySet, offset time.Duration) ([]pgs.DisplaySet, error) {
+	shifted, err := Shift(b, offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(shifted) != 0 {
+		if shifted[0].CompositionState != pgs.EpochStart {
+			return nil, errors.New("second stream does not start with an Epoch Start")
+		}
+		if n := len(a); n != 0 && shifted[0].PresentationTime < a[n-1].PresentationTime {
+			return nil, fmt.Errorf("second stream starts at %s, before the end of the first at %s",
+				shifted[0].PresentationTime, a[n-1].PresentationTime)
+		}
+	}
+	joined := make([]pgs.DisplaySet, 0, len(a)+len(shifted))
+	joined = append(joined, a...)
+	joined = append(joined, shifted...)
+	return renumber(joined), nil
+}
+
+// renumber numbers the compositions of the stream from zero and the
+// versions of each palette and object from zero within each epoch.
+// Definitions repeated unchanged in version, as by Acquisition Points,
+// keep the same version.
+func renumber(stream []pgs.DisplaySet) []pgs.DisplaySet {
+	type version struct{ orig, new uint8 }
+	palettes := make(map[uint8]version)
+	objects := make(map[uint16]version)
+	next := func(v version, ok bool, orig uint8) version {
+		switch {
+		case !ok:
+			return version{orig, 0}
+		case v.orig == orig:
+			return v
+		default:
+			return version{orig, v.new + 1}
+		}
+	}
+
+	renumbered := make([]pgs.DisplaySet, len(stream))
+	for i := range stream {
+		ds := stream[i]
+		ds.CompositionNumber = uint16(i)
+		if ds.CompositionState == pgs.EpochStart {
+			palettes = make(map[uint8]version)
+			objects = make(map[uint16]version)
+		}
+		if len(ds.Palettes) != 0 {
+			ds.Palettes = append(pgs.Palettes{}, ds.Palettes...)
+			for j := range ds.Palettes {
+				p := &ds.Palettes[j]
+				v, ok := palettes[p.ID]
+				v = next(v, ok, p.Version)
+				palettes[p.ID] = v
+				p.Version = v.new
+			}
+		}
+		if len(ds.Objects) != 0 {
+			ds.Objects = append([]pgs.Object{}, ds.Objects...)
+			for j := range ds.Objects {
+				obj := &ds.Objects[j]
+				v, ok := objects[obj.ID]
+				v = next(v, ok, obj.Version)
+				objects[obj.ID] = v
+				obj.Version = v.new
+			}
+		}
+		renumbered[i] = ds
+	}
+	return renumbered
+}
@@ -0,0 +1,53 @@
+package trans
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// ScheduleError reports a display set that the decoder model cannot
+// decode in time for its presentation.
+type ScheduleError struct {
+	Index        int           // Index of the display set
+	DecodingTime time.Duration // Time decoding needs to start
+	Earliest     time.Duration // Earliest time decoding can start
+}
+
+func (err *ScheduleError) Error() string {
+	return fmt.Sprintf("display set %d: decoding needs to start at %s, but cannot start before %s",
+		err.Index, err.DecodingTime, err.Earliest)
+}
+
+// RecomputeDecodingTimes sets the decoding time of each display set to
+// its presentation time minus the duration the decoder model needs to
+// decode it. Decoding cannot start before the previous display set is
+// presented, so display sets that would need to are reported as
+// impossible to schedule, but still decode as early as the model needs.
+// Decoding times before the start of the stream are clamped to zero.
+func RecomputeDecodingTimes(stream []pgs.DisplaySet) ([]pgs.DisplaySet, []ScheduleError, error) {
+	retimed := make([]pgs.DisplaySet, len(stream))
+	var errs []ScheduleError
+	e := pgs.NewEpoch()
+	for i := range stream {
+		ds := &stream[i]
+		retimed[i] = *ds
+		dts := ds.PresentationTime - pgs.DecodeDuration(ds, e)
+		var earliest time.Duration
+		if i != 0 {
+			earliest = stream[i-1].PresentationTime
+		}
+		if dts < earliest {
+			errs = append(errs, ScheduleError{Index: i, DecodingTime: dts, Earliest: earliest})
+		}
+		if dts < 0 {
+			dts = 0
+		}
+		retimed[i].DecodingTime = dts
+		if err := e.Apply(ds); err != nil {
+			return nil, nil, fmt.Errorf("display set %d/%d: %w", i, len(stream), err)
+		}
+	}
+	return retimed, errs, nil
+}
@@ -0,0 +1,47 @@
+package trans
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+func TestRecomputeDecodingTimes(t *testing.T) {
+	stream := testStream(t)
+	// Present the Epoch Start at zero and define the new object before
+	// the fade is presented
+	stream[0].PresentationTime = 0
+	stream[2].PresentationTime = stream[1].PresentationTime + 10*time.Microsecond
+	retimed, errs, err := RecomputeDecodingTimes(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ds := range retimed {
+		want := ds.PresentationTime - pgs.DecodeDuration(&stream[i], epochBefore(t, stream, i))
+		if want < 0 {
+			want = 0
+		}
+		if ds.DecodingTime != want {
+			t.Errorf("display set %d: decoded at %s, want %s", i, ds.DecodingTime, want)
+		}
+	}
+	if retimed[0].DecodingTime != 0 {
+		t.Errorf("display set 0: decoded at %s, want clamped to zero", retimed[0].DecodingTime)
+	}
+	if len(errs) != 2 || errs[0].Index != 0 || errs[1].Index != 2 || errs[1].Earliest != stream[1].PresentationTime {
+		t.Errorf("got schedule errors %v, want display sets 0 and 2", errs)
+	}
+}
+
+// epochBefore returns the state of the epoch before display set i.
+func epochBefore(t *testing.T, stream []pgs.DisplaySet, i int) *pgs.Epoch {
+	t.Helper()
+	e := pgs.NewEpoch()
+	for j := 0; j < i; j++ {
+		if err := e.Apply(&stream[j]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
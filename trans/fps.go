@@ -0,0 +1,39 @@
+package trans
+
+import (
+	"fmt"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// ScaleTime converts the times of a stream between frame rates, such as
+// for a PAL speedup from 23.976 to 25 fps. Frames are kept, so each
+// presentation time is scaled by from/to and snapped to the nearest
+// frame of the target rate. Decoding times keep their original lead on
+// presentation times. The frame rate code of each composition is set to
+// match the target rate, when one exists.
+func ScaleTime(stream []pgs.DisplaySet, from, to pgs.Rate) ([]pgs.DisplaySet, error) {
+	if from.Num <= 0 || from.Den <= 0 || to.Num <= 0 || to.Den <= 0 {
+		return nil, fmt.Errorf("invalid frame rates: %s to %s", from, to)
+	}
+	code, hasCode := to.FrameRate()
+	scaled := make([]pgs.DisplaySet, len(stream))
+	for i := range stream {
+		ds := &stream[i]
+		s := &scaled[i]
+		*s = *ds
+		s.PresentationTime = to.Time(from.Frame(ds.PresentationTime))
+		s.DecodingTime = s.PresentationTime - (ds.PresentationTime - ds.DecodingTime)
+		if s.DecodingTime < 0 {
+			s.DecodingTime = 0
+		}
+		if s.PresentationTime > pgs.MaxTime {
+			return nil, fmt.Errorf("display set %d/%d: presentation time %s scaled to %s overflows timestamp",
+				i, len(stream), ds.PresentationTime, s.PresentationTime)
+		}
+		if hasCode {
+			s.FrameRate = code
+		}
+	}
+	return scaled, nil
+}
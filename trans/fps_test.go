@@ -0,0 +1,55 @@
+package trans
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+func TestScaleTime(t *testing.T) {
+	film, pal := pgs.Rate{Num: 24000, Den: 1001}, pgs.Rate{Num: 25, Den: 1}
+	stream := make([]pgs.DisplaySet, 3)
+	for i, frame := range []int64{24, 1000, 86400} {
+		stream[i].PresentationTime = film.Time(frame)
+		stream[i].DecodingTime = stream[i].PresentationTime - 50*time.Millisecond
+		stream[i].FrameRate = pgs.FrameRate23976
+	}
+
+	scaled, err := ScaleTime(stream, film, pal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ds := range scaled {
+		if got, want := pal.Frame(ds.PresentationTime), film.Frame(stream[i].PresentationTime); got != want {
+			t.Errorf("display set %d: scaled to frame %d, want %d", i, got, want)
+		}
+		if ds.PresentationTime-ds.DecodingTime != 50*time.Millisecond {
+			t.Errorf("display set %d: decoding lead changed to %s", i, ds.PresentationTime-ds.DecodingTime)
+		}
+		if ds.FrameRate != pgs.FrameRate25 {
+			t.Errorf("display set %d: frame rate code %s, want %s", i, ds.FrameRate, pgs.FrameRate25)
+		}
+	}
+	if d, want := scaled[0].PresentationTime, 960*time.Millisecond; d != want {
+		t.Errorf("frame 24 scaled to %s, want %s", d, want)
+	}
+
+	back, err := ScaleTime(scaled, pal, film)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ds := range back {
+		if ds.PresentationTime != stream[i].PresentationTime || ds.DecodingTime != stream[i].DecodingTime {
+			t.Errorf("display set %d: round trip to %s/%s, want %s/%s", i,
+				ds.PresentationTime, ds.DecodingTime, stream[i].PresentationTime, stream[i].DecodingTime)
+		}
+		if ds.FrameRate != pgs.FrameRate23976 {
+			t.Errorf("display set %d: frame rate code %s, want %s", i, ds.FrameRate, pgs.FrameRate23976)
+		}
+	}
+
+	if _, err := ScaleTime(stream, film, pgs.Rate{}); err == nil {
+		t.Error("scaling to a zero rate succeeded")
+	}
+}
@@ -0,0 +1,432 @@
+package trans
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Merge interleaves two streams by time into one that shows both at
+// once, such as subtitles in two languages, one at the top and one at
+// the bottom of the screen. The images of each event of b that overlap
+// those of any event of a are moved to the top, mirroring their
+// position, so that two tracks both at the bottom are shown one above
+// the other. Each stream has its own window and object, with ID 0 for
+// a and 1 for b, and its own range of entries in a shared palette, so
+// that when only one stream changes, only its object or its entries are
+// redefined in a Normal composition or palette update. An Epoch Start
+// begins each run of images after a clear, or where the windows would
+// otherwise overlap. The images of each stream are combined into one
+// object and more colors than fit in its entries are mapped to the
+// nearest others, least frequent first. Images that still overlap are
+// an error. The screen size and frame rate are those of a.
+func Merge(a, b []pgs.DisplaySet) ([]pgs.DisplaySet, error) {
+	if len(a) == 0 && len(b) == 0 {
+		return nil, nil
+	}
+	var pc pgs.PresentationComposition
+	switch {
+	case len(a) == 0:
+		pc = b[0].PresentationComposition
+	case len(b) != 0 && (a[0].Width != b[0].Width || a[0].Height != b[0].Height):
+		return nil, fmt.Errorf("second stream is %dx%d, but first is %dx%d",
+			b[0].Width, b[0].Height, a[0].Width, a[0].Height)
+	default:
+		pc = a[0].PresentationComposition
+	}
+	pc.CompositionState = pgs.Normal
+	pc.CompositionObjects = nil
+	pc.PaletteUpdate = false
+	pc.PaletteID = 0
+	eventsA, err := pgs.Events(a, pgs.ColorSpace{})
+	if err != nil {
+		return nil, fmt.Errorf("first stream: %w", err)
+	}
+	eventsB, err := pgs.Events(b, pgs.ColorSpace{})
+	if err != nil {
+		return nil, fmt.Errorf("second stream: %w", err)
+	}
+	eventsB = placeEvents(eventsB, eventsA, int(pc.Height))
+
+	var times []time.Duration
+	for _, events := range [][]pgs.Event{eventsA, eventsB} {
+		for _, ev := range events {
+			times = append(times, ev.Start)
+			if ev.End != 0 {
+				times = append(times, ev.End)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	var states []mergeState
+	var ia, ib int
+	for i, t := range times {
+		if i != 0 && t == times[i-1] {
+			continue
+		}
+		st := mergeState{t: t, events: [2]*pgs.Event{activeEvent(eventsA, &ia, t), activeEvent(eventsB, &ib, t)}}
+		for src, ev := range st.events {
+			if ev != nil {
+				st.rects[src] = eventRect(ev)
+			}
+		}
+		states = append(states, st)
+	}
+
+	m := &merger{pc: pc, e: pgs.NewEpoch()}
+	next := 0 // Index of the state that starts the next epoch
+	for i := range states {
+		st := &states[i]
+		if st.empty() {
+			if err := m.clear(st.t); err != nil {
+				return nil, fmt.Errorf("at %s: %w", st.t, err)
+			}
+			continue
+		}
+		start := i == next || states[i-1].empty()
+		if start {
+			if m.windows, next, err = mergeWindows(states, i); err != nil {
+				return nil, err
+			}
+		}
+		if err := m.compose(st, start); err != nil {
+			return nil, fmt.Errorf("at %s: %w", st.t, err)
+		}
+	}
+	return m.stream, nil
+}
+
+// placeEvents moves the images of each event of b whose area overlaps
+// that of any event of a to the top of a screen of the height, so that
+// their distance from the top is what it was from the bottom.
+func placeEvents(b, a []pgs.Event, height int) []pgs.Event {
+	placed := make([]pgs.Event, len(b))
+	for i, ev := range b {
+		r := eventRect(&ev)
+		for j := range a {
+			if !r.Overlaps(eventRect(&a[j])) {
+				continue
+			}
+			dy := height - r.Max.Y - r.Min.Y
+			images := make([]pgs.EventImage, len(ev.Images))
+			for k, img := range ev.Images {
+				img.Y += dy
+				images[k] = img
+			}
+			ev.Images = images
+			break
+		}
+		placed[i] = ev
+	}
+	return placed
+}
+
+// eventRect returns the area covered by the images of the event.
+func eventRect(ev *pgs.Event) image.Rectangle {
+	var r image.Rectangle
+	for _, img := range ev.Images {
+		r = r.Union(img.Rect())
+	}
+	return r
+}
+
+// activeEvent returns the event shown at t, advancing i past the events
+// that end by t, or nil if none is shown.
+func activeEvent(events []pgs.Event, i *int, t time.Duration) *pgs.Event {
+	for *i < len(events) && events[*i].End != 0 && events[*i].End <= t {
+		*i++
+	}
+	if *i < len(events) && events[*i].Start <= t {
+		return &events[*i]
+	}
+	return nil
+}
+
+const (
+	// mergeEntries is the number of palette entries of each stream, from
+	// entry ID 0 for the first and mergeEntries for the second.
+	mergeEntries = 127
+	// mergeFill is the palette entry ID of transparent pixels, which is
+	// never defined.
+	mergeFill = 255
+)
+
+// mergeState is what each stream shows from a time until the next.
+type mergeState struct {
+	t      time.Duration
+	events [2]*pgs.Event      // Event of each stream, or nil if not shown
+	rects  [2]image.Rectangle // Area of the images of each stream
+}
+
+func (st *mergeState) empty() bool {
+	return st.events[0] == nil && st.events[1] == nil
+}
+
+// mergeWindows returns a window for each stream covering its images
+// from state i until the screen is cleared or the windows would
+// overlap, and the index of the state after.
+func mergeWindows(states []mergeState, i int) ([]pgs.Window, int, error) {
+	var w [2]image.Rectangle
+	j := i
+	for ; j < len(states) && !states[j].empty(); j++ {
+		r := [2]image.Rectangle{w[0].Union(states[j].rects[0]), w[1].Union(states[j].rects[1])}
+		if r[0].Overlaps(r[1]) {
+			if j == i {
+				return nil, 0, fmt.Errorf("at %s: images of both streams overlap at %v",
+					states[j].t, r[0].Intersect(r[1]))
+			}
+			break
+		}
+		w = r
+	}
+	var windows []pgs.Window
+	for src, r := range w {
+		if !r.Empty() {
+			windows = append(windows, pgs.Window{ID: uint8(src), X: uint16(r.Min.X), Y: uint16(r.Min.Y),
+				Width: uint16(r.Dx()), Height: uint16(r.Dy())})
+		}
+	}
+	return windows, j, nil
+}
+
+// merger compiles the states of two streams into one stream.
+type merger struct {
+	pc       pgs.PresentationComposition
+	e        *pgs.Epoch
+	stream   []pgs.DisplaySet
+	windows  []pgs.Window
+	sources  [2]*mergeSource // What each stream shows, or nil
+	defined  [2]bool         // Whether the object of each stream is defined in the epoch
+	versions [2]uint8        // Version of the object of each stream
+	entries  []pgs.PaletteEntry
+	palette  uint8 // Version of the palette
+}
+
+// emit appends the display set with its decoding time set by the
+// decoder model, but not before the preceding presentation.
+func (m *merger) emit(ds *pgs.DisplaySet) error {
+	ds.CompositionNumber = uint16(len(m.stream))
+	ds.DecodingTime = ds.PresentationTime - pgs.DecodeDuration(ds, m.e)
+	if n := len(m.stream); n != 0 && ds.DecodingTime < m.stream[n-1].PresentationTime {
+		ds.DecodingTime = m.stream[n-1].PresentationTime
+	}
+	if ds.DecodingTime < 0 {
+		ds.DecodingTime = 0
+	}
+	if err := m.e.Apply(ds); err != nil {
+		return err
+	}
+	m.stream = append(m.stream, *ds)
+	return nil
+}
+
+// compose emits a composition of the state, which redefines only the
+// objects and palette entries of the streams that change, or an Epoch
+// Start with the windows that defines them all.
+func (m *merger) compose(st *mergeState, start bool) error {
+	ds := pgs.DisplaySet{PresentationTime: st.t, PresentationComposition: m.pc}
+	if start {
+		ds.CompositionState = pgs.EpochStart
+		ds.Windows = m.windows
+		m.sources, m.defined, m.versions = [2]*mergeSource{}, [2]bool{}, [2]uint8{}
+		m.entries, m.palette = nil, 0
+	}
+	moved := start // Whether the composition objects change
+	for src, ev := range st.events {
+		cur := m.sources[src]
+		switch {
+		case ev == nil:
+			if cur != nil {
+				m.sources[src], moved = nil, true
+			}
+		case cur != nil && sameImages(cur.ev.Images, ev.Images):
+			cur.ev = ev
+		default:
+			if m.defined[src] {
+				m.versions[src]++
+			}
+			s := newMergeSource(src, ev)
+			obj, err := s.object(uint16(src), m.versions[src])
+			if err != nil {
+				return fmt.Errorf("stream %d: %w", src+1, err)
+			}
+			ds.Objects = append(ds.Objects, *obj)
+			m.sources[src], m.defined[src], moved = s, true, true
+		}
+	}
+
+	var entries []pgs.PaletteEntry
+	for _, s := range m.sources {
+		if s != nil {
+			entries = append(entries, s.entries()...)
+			ds.CompositionObjects = append(ds.CompositionObjects, s.co)
+		}
+	}
+	if start || !sameEntries(entries, m.entries) {
+		if !start {
+			m.palette++
+		}
+		ds.Palettes = pgs.Palettes{{Version: m.palette, Entries: entries}}
+		m.entries = entries
+	} else if !moved {
+		return nil // Nothing changes on screen
+	}
+	ds.PaletteUpdate = !moved
+	return m.emit(&ds)
+}
+
+// clear emits a composition without objects, unless already clear.
+func (m *merger) clear(t time.Duration) error {
+	if m.sources == [2]*mergeSource{} {
+		return nil
+	}
+	m.sources = [2]*mergeSource{}
+	ds := pgs.DisplaySet{PresentationTime: t, PresentationComposition: m.pc, Windows: m.windows}
+	return m.emit(&ds)
+}
+
+// mergeSource is the event shown by a stream with its entry IDs mapped
+// to the range of the stream in the merged palette.
+type mergeSource struct {
+	src  int
+	ev   *pgs.Event
+	ids  [256]uint8 // Merged entry ID of each entry ID of the event
+	from []uint8    // Entry ID of the event of each merged entry
+	co   pgs.CompositionObject
+}
+
+// newMergeSource allocates the entries of the stream to the entry IDs
+// used by the images of the event, most frequent first, and maps the
+// rest to the entry nearest in color.
+func newMergeSource(src int, ev *pgs.Event) *mergeSource {
+	var defined [256]bool
+	var colors [256]color.NYCbCrA
+	for _, e := range ev.Palette {
+		defined[e.ID], colors[e.ID] = true, e.NYCbCrA
+	}
+	var counts [256]int
+	for _, img := range ev.Images {
+		for _, c := range img.Image.Pix {
+			counts[c]++
+		}
+	}
+	var order []int
+	for id, n := range counts {
+		if n != 0 && defined[id] {
+			order = append(order, id)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+
+	s := &mergeSource{src: src, ev: ev}
+	for i := range s.ids {
+		s.ids[i] = mergeFill
+	}
+	var kept []pgs.PaletteEntry
+	for _, id := range order {
+		if len(kept) < mergeEntries {
+			s.ids[id] = uint8(src*mergeEntries + len(kept))
+			s.from = append(s.from, uint8(id))
+			kept = append(kept, pgs.PaletteEntry{ID: s.ids[id], NYCbCrA: colors[id]})
+		} else {
+			s.ids[id] = uint8(nearestEntry(kept, colors[id]))
+		}
+	}
+	return s
+}
+
+// entries returns the merged palette entries of the stream in the
+// colors of the current event. Entries it no longer defines are
+// transparent.
+func (s *mergeSource) entries() []pgs.PaletteEntry {
+	var colors [256]color.NYCbCrA
+	for _, e := range s.ev.Palette {
+		colors[e.ID] = e.NYCbCrA
+	}
+	entries := make([]pgs.PaletteEntry, len(s.from))
+	for i, id := range s.from {
+		entries[i] = pgs.PaletteEntry{ID: uint8(s.src*mergeEntries + i), NYCbCrA: colors[id]}
+	}
+	return entries
+}
+
+// object combines the images of the event into one object with the
+// merged entry IDs and sets the composition object that shows it in
+// the window of the stream.
+func (s *mergeSource) object(id uint16, version uint8) (*pgs.Object, error) {
+	r := eventRect(s.ev)
+	dst := image.NewPaletted(image.Rectangle{Max: r.Size()}, nil)
+	for i := range dst.Pix {
+		dst.Pix[i] = mergeFill
+	}
+	for _, img := range s.ev.Images {
+		m := img.Image
+		off := img.Rect().Min.Sub(r.Min)
+		for y := 0; y < m.Rect.Dy(); y++ {
+			for x := 0; x < m.Rect.Dx(); x++ {
+				if c := s.ids[m.ColorIndexAt(m.Rect.Min.X+x, m.Rect.Min.Y+y)]; c != mergeFill {
+					dst.SetColorIndex(off.X+x, off.Y+y, c)
+				}
+			}
+		}
+	}
+	s.co = pgs.CompositionObject{ObjectID: id, WindowID: uint8(id), X: uint16(r.Min.X), Y: uint16(r.Min.Y)}
+	return pgs.NewObject(id, version, dst, nil)
+}
+
+// sameImages reports whether both have the same images at the same
+// positions.
+func sameImages(a, b []pgs.EventImage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		ai, bi := a[i].Image, b[i].Image
+		if a[i].Rect() != b[i].Rect() {
+			return false
+		}
+		w := ai.Rect.Dx()
+		for y := 0; y < ai.Rect.Dy(); y++ {
+			j := ai.PixOffset(ai.Rect.Min.X, ai.Rect.Min.Y+y)
+			k := bi.PixOffset(bi.Rect.Min.X, bi.Rect.Min.Y+y)
+			if !bytes.Equal(ai.Pix[j:j+w], bi.Pix[k:k+w]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sameEntries(a, b []pgs.PaletteEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nearestEntry returns the ID of the entry closest in color to c.
+func nearestEntry(palette []pgs.PaletteEntry, c color.NYCbCrA) int {
+	best, bestDist := 0, -1
+	for _, e := range palette {
+		dist := 0
+		for _, d := range [...]int{
+			int(e.Y) - int(c.Y), int(e.Cb) - int(c.Cb), int(e.Cr) - int(c.Cr), int(e.A) - int(c.A),
+		} {
+			dist += d * d
+		}
+		if bestDist == -1 || dist < bestDist {
+			best, bestDist = int(e.ID), dist
+		}
+	}
+	return best
+}
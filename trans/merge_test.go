@@ -0,0 +1,158 @@
+package trans
+
+import (
+	"fmt"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+func TestMerge(t *testing.T) {
+	a := testStream(t)
+	b, err := Shift(testStream(t), 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0].Windows = []pgs.Window{{X: 4, Y: 48, Width: 8, Height: 8}}
+	for i := range b {
+		if len(b[i].CompositionObjects) != 0 {
+			b[i].CompositionObjects = []pgs.CompositionObject{{X: 4, Y: 48}}
+		}
+	}
+
+	merged, err := Merge(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValid(t, "merged", merged)
+	checkEvents(t, "merged", merged, sec(1), sec(1.5), sec(1.5), sec(2), sec(2), sec(2.5),
+		sec(2.5), sec(3), sec(3), sec(3.5), sec(3.5), sec(4), sec(4), sec(4.5))
+
+	e := pgs.NewEpoch()
+	for i := range merged {
+		ds := &merged[i]
+		if err := e.Apply(ds); err != nil {
+			t.Fatal(err)
+		}
+		if ds.PresentationTime != sec(1.5) {
+			continue
+		}
+		if len(e.Windows) != 2 || len(ds.CompositionObjects) != 2 {
+			t.Fatalf("got %d windows and %d objects shown at 1.5s, want 2 each",
+				len(e.Windows), len(ds.CompositionObjects))
+		}
+		p := e.Palettes[ds.PaletteID]
+		if len(p.Entries) != 2 {
+			t.Errorf("got %d palette entries at 1.5s, want 1 for each stream", len(p.Entries))
+		}
+	}
+}
+
+func TestMergeOneStreamChanges(t *testing.T) {
+	a := testStream(t)
+	// A line shown at the bottom from 0.5s to 5s, throughout a
+	b := testStream(t)[:1]
+	b[0].PresentationTime, b[0].DecodingTime = sec(0.5), sec(0.5)
+	b[0].Windows = []pgs.Window{{X: 4, Y: 48, Width: 8, Height: 8}}
+	b[0].CompositionObjects = []pgs.CompositionObject{{X: 4, Y: 48}}
+	clear := b[0]
+	clear.PresentationTime, clear.DecodingTime = sec(5), sec(5)
+	clear.CompositionState = pgs.Normal
+	clear.CompositionNumber = 1
+	clear.Palettes, clear.Objects, clear.CompositionObjects = nil, nil, nil
+	b = append(b, clear)
+
+	merged, err := Merge(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValid(t, "merged", merged)
+	checkEvents(t, "merged", merged, sec(0.5), sec(1), sec(1), sec(2), sec(2), sec(3),
+		sec(3), sec(4), sec(4), sec(5))
+	want := []struct {
+		state   pgs.CompositionState
+		update  bool
+		objects []uint16 // IDs of the objects defined
+		shown   int      // Number of objects shown
+	}{
+		{pgs.EpochStart, false, []uint16{1}, 1},
+		{pgs.Normal, false, []uint16{0}, 2},
+		{pgs.Normal, true, nil, 2},
+		{pgs.Normal, false, []uint16{0}, 2},
+		{pgs.Normal, false, nil, 1},
+		{pgs.Normal, false, nil, 0},
+	}
+	if len(merged) != len(want) {
+		t.Fatalf("got %d display sets, want %d", len(merged), len(want))
+	}
+	for i, w := range want {
+		ds := &merged[i]
+		var objects []uint16
+		for _, obj := range ds.Objects {
+			objects = append(objects, obj.ID)
+		}
+		if ds.CompositionState != w.state || ds.PaletteUpdate != w.update ||
+			fmt.Sprint(objects) != fmt.Sprint(w.objects) || len(ds.CompositionObjects) != w.shown {
+			t.Errorf("display set %d: got %s, palette update %t, objects %v defined, %d shown; want %s, %t, %v, %d",
+				i, ds.CompositionState, ds.PaletteUpdate, objects, len(ds.CompositionObjects),
+				w.state, w.update, w.objects, w.shown)
+		}
+		for _, co := range ds.CompositionObjects {
+			if uint16(co.WindowID) != co.ObjectID {
+				t.Errorf("display set %d: object %d shown in window %d", i, co.ObjectID, co.WindowID)
+			}
+		}
+	}
+	if merged[3].Objects[0].Version != 1 {
+		t.Errorf("new object of first stream has version %d, want 1", merged[3].Objects[0].Version)
+	}
+}
+
+func TestMergeBothAtBottom(t *testing.T) {
+	bottom := func(stream []pgs.DisplaySet) []pgs.DisplaySet {
+		stream[0].Windows = []pgs.Window{{X: 4, Y: 48, Width: 8, Height: 8}}
+		for i := range stream {
+			if len(stream[i].CompositionObjects) != 0 {
+				stream[i].CompositionObjects = []pgs.CompositionObject{{X: 4, Y: 48}}
+			}
+		}
+		return stream
+	}
+	a := bottom(testStream(t))
+	b, err := Shift(bottom(testStream(t)), 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := Merge(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValid(t, "merged", merged)
+	e := pgs.NewEpoch()
+	for i := range merged {
+		if err := e.Apply(&merged[i]); err != nil {
+			t.Fatal(err)
+		}
+		if merged[i].PresentationTime != sec(1.5) {
+			continue
+		}
+		if len(e.Windows) != 2 {
+			t.Fatalf("got %d windows at 1.5s, want 2", len(e.Windows))
+		}
+		wa, wb := e.Windows[0], e.Windows[1]
+		ra := image.Rect(int(wa.X), int(wa.Y), int(wa.X)+int(wa.Width), int(wa.Y)+int(wa.Height))
+		rb := image.Rect(int(wb.X), int(wb.Y), int(wb.X)+int(wb.Width), int(wb.Y)+int(wb.Height))
+		if ra.Overlaps(rb) {
+			t.Errorf("windows %v and %v overlap", ra, rb)
+		}
+		if want := image.Rect(4, 48, 12, 56); ra != want {
+			t.Errorf("first window at %v, want %v", ra, want)
+		}
+		if want := image.Rect(4, 8, 12, 16); rb != want {
+			t.Errorf("second window at %v, want %v at the top", rb, want)
+		}
+	}
+}
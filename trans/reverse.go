@@ -1,46 +1,44 @@
 package trans
 
 import (
-	"errors"
 	"fmt"
 	"time"
 
 	"github.com/andrewarchi/transup/pgs"
 )
 
+// Reverse reverses the stream in time within a video of duration d, so
+// that what is shown from t1 to t2 is shown from d-t2 to d-t1. What is
+// on screen at the end of the stream is shown from the start. The
+// stream is compiled from its events anew, so any epoch structure can
+// be reversed, and decoding times are recomputed.
 func Reverse(stream []pgs.DisplaySet, d time.Duration) ([]pgs.DisplaySet, error) {
-	if len(stream)%2 != 0 {
-		return nil, errors.New("len not even")
+	if len(stream) == 0 {
+		return nil, nil
 	}
-	rev := make([]pgs.DisplaySet, len(stream))
-	j := 0
-	for i := len(stream) - 2; i >= 0; i -= 2 {
-		draw := &stream[i]
-		clear := &stream[i+1]
-		if draw.PresentationTime > d || draw.DecodingTime > d {
-			return nil, fmt.Errorf("display set %d/%d: presentation %s or decoding time %s greater than duration %s",
-				i, len(stream), draw.PresentationTime, draw.DecodingTime, d)
+	for i := range stream {
+		ds := &stream[i]
+		if ds.PresentationTime > d {
+			return nil, fmt.Errorf("display set %d/%d: presentation time %s greater than duration %s",
+				i, len(stream), ds.PresentationTime, d)
 		}
-		if clear.PresentationTime > d || clear.DecodingTime > d {
-			return nil, fmt.Errorf("display set %d/%d: presentation %s or decoding time %s greater than duration %s",
-				i+1, len(stream), draw.PresentationTime, draw.DecodingTime, d)
-		}
-		if draw.CompositionState != pgs.EpochStart {
-			return nil, fmt.Errorf("display set %d/%d: composition state is not epoch start, got %x",
-				i, len(stream), draw.CompositionState)
+	}
+	events, err := pgs.Events(stream, pgs.ColorSpace{})
+	if err != nil {
+		return nil, err
+	}
+	rev := make([]pgs.Event, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		ev := events[i]
+		start, end := ev.Start, ev.End
+		if end == 0 {
+			end = d
 		}
-		if clear.CompositionState != pgs.Normal ||
-			clear.PaletteUpdate || clear.Palette != nil ||
-			len(clear.Objects) != 0 || clear.Object != nil {
-			return nil, fmt.Errorf("display set %d/%d: appears to not clear objects", i+1, len(stream))
+		if start == end {
+			continue // Shown only at the end of the video
 		}
-		rev[j] = *draw
-		rev[j].PresentationTime = d - clear.PresentationTime
-		rev[j].DecodingTime = d - clear.DecodingTime
-		rev[j+1] = *clear
-		rev[j+1].PresentationTime = d - draw.PresentationTime
-		rev[j+1].DecodingTime = d - draw.DecodingTime
-		j += 2
+		ev.Start, ev.End = d-end, d-start
+		rev = append(rev, ev)
 	}
-	return rev, nil
+	return pgs.CompileEvents(rev, stream[0].PresentationComposition)
 }
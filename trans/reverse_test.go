@@ -0,0 +1,47 @@
+package trans
+
+import (
+	"testing"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+func TestReverse(t *testing.T) {
+	stream := testStream(t)
+	// Repeat the faded new version in an Acquisition Point at 4s,
+	// instead of clearing it, so that it is shown to the end
+	ap := stream[0]
+	ap.PresentationTime, ap.DecodingTime = stream[3].PresentationTime, stream[3].DecodingTime
+	ap.CompositionNumber = stream[3].CompositionNumber
+	ap.CompositionState = pgs.AcquisitionPoint
+	ap.Palettes = stream[1].Palettes
+	ap.Objects = stream[2].Objects
+	stream[3] = ap
+	checkEvents(t, "original", stream, sec(1), sec(2), sec(2), sec(3), sec(3), 0)
+
+	rev, err := Reverse(stream, sec(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValid(t, "reversed", rev)
+	checkEvents(t, "reversed", rev, 0, sec(7), sec(7), sec(8), sec(8), sec(9))
+	events, err := pgs.Events(rev, pgs.ColorSpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, alpha := range []uint8{0x80, 0x80, 0xff} {
+		if a := events[i].Palette[1].A; a != alpha {
+			t.Errorf("reversed: event %d has alpha 0x%x, want 0x%x", i, a, alpha)
+		}
+	}
+	if !rev[2].PaletteUpdate {
+		t.Error("reversed: fade is not a palette update")
+	}
+
+	again, err := Reverse(rev, sec(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValid(t, "reversed twice", again)
+	checkEvents(t, "reversed twice", again, sec(1), sec(2), sec(2), sec(3), sec(3), sec(10))
+}
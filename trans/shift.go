@@ -0,0 +1,65 @@
+package trans
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Offset is a time offset applied to the display sets presented at or
+// after Start.
+type Offset struct {
+	Start  time.Duration
+	Offset time.Duration
+}
+
+// Shift offsets the presentation and decoding times of every display
+// set by a constant.
+func Shift(stream []pgs.DisplaySet, offset time.Duration) ([]pgs.DisplaySet, error) {
+	return ShiftRanges(stream, []Offset{{Start: 0, Offset: offset}})
+}
+
+// ShiftRanges offsets the times of display sets piecewise. Each display
+// set is shifted by the offset with the latest Start at or before its
+// original presentation time and display sets before the first Start
+// are unchanged. Offsets must be sorted by Start. Shifting fails when
+// times would be negative, overflow the 90 kHz timestamp, or reorder
+// the display sets.
+func ShiftRanges(stream []pgs.DisplaySet, offsets []Offset) ([]pgs.DisplaySet, error) {
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i].Start < offsets[i-1].Start {
+			return nil, errors.New("offsets not sorted by start")
+		}
+	}
+	shifted := make([]pgs.DisplaySet, len(stream))
+	j := -1
+	for i := range stream {
+		ds := &stream[i]
+		for j+1 < len(offsets) && offsets[j+1].Start <= ds.PresentationTime {
+			j++
+		}
+		shifted[i] = *ds
+		if j < 0 {
+			continue
+		}
+		d := offsets[j].Offset
+		s := &shifted[i]
+		s.PresentationTime += d
+		s.DecodingTime += d
+		if s.DecodingTime < 0 || s.PresentationTime < 0 {
+			return nil, fmt.Errorf("display set %d/%d: presentation %s or decoding time %s shifted by %s is negative",
+				i, len(stream), ds.PresentationTime, ds.DecodingTime, d)
+		}
+		if s.DecodingTime > pgs.MaxTime || s.PresentationTime > pgs.MaxTime {
+			return nil, fmt.Errorf("display set %d/%d: presentation %s or decoding time %s shifted by %s overflows timestamp",
+				i, len(stream), ds.PresentationTime, ds.DecodingTime, d)
+		}
+		if i != 0 && s.PresentationTime < shifted[i-1].PresentationTime {
+			return nil, fmt.Errorf("display set %d/%d: presentation time %s shifted by %s is before previous display set at %s",
+				i, len(stream), ds.PresentationTime, d, shifted[i-1].PresentationTime)
+		}
+	}
+	return shifted, nil
+}
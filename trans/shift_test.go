@@ -0,0 +1,57 @@
+package trans
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+func TestShiftRanges(t *testing.T) {
+	stream := make([]pgs.DisplaySet, 4)
+	for i := range stream {
+		stream[i].PresentationTime = time.Duration(i+1) * time.Second
+		stream[i].DecodingTime = stream[i].PresentationTime - 100*time.Millisecond
+	}
+
+	tests := []struct {
+		name    string
+		offsets []Offset
+		times   []time.Duration // Presentation times, or nil for an error
+	}{
+		{"constant", []Offset{{0, sec(1.5)}}, []time.Duration{sec(2.5), sec(3.5), sec(4.5), sec(5.5)}},
+		{"negative", []Offset{{0, -sec(0.5)}}, []time.Duration{sec(0.5), sec(1.5), sec(2.5), sec(3.5)}},
+		{"piecewise", []Offset{{sec(2), sec(1)}, {sec(4), sec(2)}},
+			[]time.Duration{sec(1), sec(3), sec(4), sec(6)}},
+		{"from start", []Offset{{sec(3), -sec(0.5)}}, []time.Duration{sec(1), sec(2), sec(2.5), sec(3.5)}},
+		{"before zero", []Offset{{0, -sec(1)}}, nil},
+		{"overflow", []Offset{{0, pgs.MaxTime - sec(3)}}, nil},
+		{"reorder", []Offset{{sec(3), -sec(2)}}, nil},
+		{"unsorted", []Offset{{sec(3), 0}, {sec(2), 0}}, nil},
+	}
+	for _, tt := range tests {
+		shifted, err := ShiftRanges(stream, tt.offsets)
+		if tt.times == nil {
+			if err == nil {
+				t.Errorf("%s: shift succeeded, want error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for i, ds := range shifted {
+			if ds.PresentationTime != tt.times[i] {
+				t.Errorf("%s: display set %d presented at %s, want %s", tt.name, i, ds.PresentationTime, tt.times[i])
+			}
+			if ds.PresentationTime-ds.DecodingTime != 100*time.Millisecond {
+				t.Errorf("%s: display set %d decoded at %s, want 100ms before presentation",
+					tt.name, i, ds.DecodingTime)
+			}
+		}
+	}
+	if stream[0].PresentationTime != sec(1) {
+		t.Error("shift modified the original stream")
+	}
+}
@@ -0,0 +1,162 @@
+package trans
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Split splits the stream at the cut points into a stream for each
+// segment between them, rebased to start at zero. Each segment is
+// trimmed, so that it begins with an Epoch Start when cut within an
+// epoch and plays on its own. A cut at zero is ignored.
+func Split(stream []pgs.DisplaySet, cuts []time.Duration) ([][]pgs.DisplaySet, error) {
+	var bounds []time.Duration
+	bounds = append(bounds, 0)
+	for i, c := range cuts {
+		if c == 0 && i == 0 {
+			continue
+		}
+		if c <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("cut %d at %s not after %s", i+1, c, bounds[len(bounds)-1])
+		}
+		bounds = append(bounds, c)
+	}
+	bounds = append(bounds, math.MaxInt64)
+
+	segments := make([][]pgs.DisplaySet, len(bounds)-1)
+	for i := range segments {
+		start, end := bounds[i], bounds[i+1]
+		trimmed, err := Trim(stream, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i+1, err)
+		}
+		for j := range trimmed {
+			ds := &trimmed[j]
+			ds.PresentationTime -= start
+			// Decoding started before the cut starts with the segment
+			if ds.DecodingTime -= start; ds.DecodingTime < 0 {
+				ds.DecodingTime = 0
+			}
+		}
+		segments[i] = trimmed
+	}
+	return segments, nil
+}
+
+// ReadCuts reads cut points from a Matroska chapter XML file, with the
+// start of each chapter of the first edition, or from a text file with
+// a time on each line. Times are durations, such as 1h2m3s, or clock
+// times, such as 01:02:03.456, optionally in OGM chapter lines, such as
+// CHAPTER01=01:02:03.456. Blank lines, lines starting with #, and OGM
+// chapter names are skipped.
+func ReadCuts(r io.Reader) ([]time.Duration, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return readChapterXML(data)
+	}
+
+	var cuts []time.Duration
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(strings.ToUpper(text), "CHAPTER") {
+			i := strings.IndexByte(text, '=')
+			if i == -1 {
+				return nil, fmt.Errorf("line %d: invalid chapter: %q", line, text)
+			}
+			if strings.HasSuffix(strings.ToUpper(text[:i]), "NAME") {
+				continue
+			}
+			text = text[i+1:]
+		}
+		d, err := ParseTime(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		cuts = append(cuts, d)
+	}
+	return cuts, sc.Err()
+}
+
+type chaptersXML struct {
+	Editions []struct {
+		Atoms []struct {
+			TimeStart string `xml:"ChapterTimeStart"`
+		} `xml:"ChapterAtom"`
+	} `xml:"EditionEntry"`
+}
+
+func readChapterXML(data []byte) ([]time.Duration, error) {
+	var doc chaptersXML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Editions) == 0 {
+		return nil, errors.New("no chapter edition")
+	}
+	var cuts []time.Duration
+	for i, atom := range doc.Editions[0].Atoms {
+		d, err := ParseTime(strings.TrimSpace(atom.TimeStart))
+		if err != nil {
+			return nil, fmt.Errorf("chapter %d: %w", i+1, err)
+		}
+		cuts = append(cuts, d)
+	}
+	return cuts, nil
+}
+
+// ParseTime parses a duration, such as 1h2m3s, or a clock time, such
+// as 01:02:03.456 or 02:03.456, with up to nanosecond precision.
+func ParseTime(s string) (time.Duration, error) {
+	if !strings.Contains(s, ":") {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, nil
+		}
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time: %q", s)
+	}
+	var d time.Duration
+	for i, part := range parts {
+		frac := ""
+		if i == len(parts)-1 {
+			if j := strings.IndexByte(part, '.'); j != -1 {
+				part, frac = part[:j], part[j+1:]
+			}
+		}
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || len(frac) > 9 {
+			return 0, fmt.Errorf("invalid time: %q", s)
+		}
+		d = d*60 + time.Duration(n)
+		if i == len(parts)-1 {
+			d *= time.Second
+			if frac != "" {
+				ns, err := strconv.ParseUint(frac+strings.Repeat("0", 9-len(frac)), 10, 32)
+				if err != nil {
+					return 0, fmt.Errorf("invalid time: %q", s)
+				}
+				d += time.Duration(ns)
+			}
+		}
+	}
+	return d, nil
+}
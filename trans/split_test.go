@@ -0,0 +1,57 @@
+package trans
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+func TestSplit(t *testing.T) {
+	stream := testStream(t)
+
+	cuts, err := ReadCuts(strings.NewReader("# chapters\nCHAPTER01=00:00:00.000\nCHAPTER01NAME=One\n\n00:00:02.5\n3.5s\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{0, sec(2.5), sec(3.5)}; !reflect.DeepEqual(cuts, want) {
+		t.Fatalf("got cuts %v, want %v", cuts, want)
+	}
+	xmlCuts, err := ReadCuts(strings.NewReader(`<?xml version="1.0"?>
+<Chapters><EditionEntry>
+<ChapterAtom><ChapterTimeStart>00:00:00.000000000</ChapterTimeStart></ChapterAtom>
+<ChapterAtom><ChapterTimeStart>00:00:02.500000000</ChapterTimeStart></ChapterAtom>
+<ChapterAtom><ChapterTimeStart>00:00:03.500000000</ChapterTimeStart></ChapterAtom>
+</EditionEntry></Chapters>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(xmlCuts, cuts) {
+		t.Fatalf("got chapter cuts %v, want %v", xmlCuts, cuts)
+	}
+
+	segments, err := Split(stream, cuts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+	for i, seg := range segments {
+		name := fmt.Sprintf("segment %d", i+1)
+		if len(seg) == 0 || seg[0].CompositionState != pgs.EpochStart {
+			t.Errorf("%s: does not start with an Epoch Start", name)
+		}
+		checkValid(t, name, seg)
+	}
+	checkEvents(t, "segment 1", segments[0], sec(1), sec(2), sec(2), sec(2.5))
+	checkEvents(t, "segment 2", segments[1], 0, sec(0.5), sec(0.5), sec(1))
+	checkEvents(t, "segment 3", segments[2], 0, sec(0.5))
+
+	if _, err := Split(stream, []time.Duration{sec(2), sec(1)}); err == nil {
+		t.Error("split at decreasing cuts succeeded")
+	}
+}
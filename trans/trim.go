@@ -0,0 +1,91 @@
+package trans
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Trim keeps the part of the stream presented from start until end,
+// without changing its times. When the cut at start is within an
+// epoch, an Epoch Start is presented at start with the windows,
+// palettes, and objects live at that point and what is on screen then,
+// and when something is still on screen at end, it is cleared at end.
+// Composition numbers and versions are renumbered.
+func Trim(stream []pgs.DisplaySet, start, end time.Duration) ([]pgs.DisplaySet, error) {
+	if start < 0 || end <= start {
+		return nil, fmt.Errorf("invalid range from %s to %s", start, end)
+	}
+	e := pgs.NewEpoch()
+	i := 0
+	for ; i < len(stream) && stream[i].PresentationTime <= start; i++ {
+		if err := e.Apply(&stream[i]); err != nil {
+			return nil, fmt.Errorf("display set %d/%d: %w", i, len(stream), err)
+		}
+	}
+
+	var trimmed []pgs.DisplaySet
+	if i != 0 {
+		shown := len(e.Composition.CompositionObjects) != 0
+		next := i < len(stream) && stream[i].PresentationTime < end &&
+			stream[i].CompositionState == pgs.EpochStart
+		if shown || !next {
+			trimmed = append(trimmed, epochStart(e, start))
+		}
+	}
+	for ; i < len(stream) && stream[i].PresentationTime < end; i++ {
+		if err := e.Apply(&stream[i]); err != nil {
+			return nil, fmt.Errorf("display set %d/%d: %w", i, len(stream), err)
+		}
+		trimmed = append(trimmed, stream[i])
+	}
+	if len(e.Composition.CompositionObjects) != 0 && i < len(stream) {
+		trimmed = append(trimmed, clearScreen(e, end))
+	}
+	return renumber(trimmed), nil
+}
+
+// epochStart creates an Epoch Start presented at t that defines the
+// state of the epoch and shows its current composition.
+func epochStart(e *pgs.Epoch, t time.Duration) pgs.DisplaySet {
+	ds := pgs.DisplaySet{PresentationTime: t, PresentationComposition: e.Composition}
+	ds.CompositionState = pgs.EpochStart
+	ds.PaletteUpdate = false
+	for _, w := range e.Windows {
+		ds.Windows = append(ds.Windows, w)
+	}
+	sort.Slice(ds.Windows, func(i, j int) bool { return ds.Windows[i].ID < ds.Windows[j].ID })
+	for _, p := range e.Palettes {
+		ds.Palettes = append(ds.Palettes, p)
+	}
+	sort.Slice(ds.Palettes, func(i, j int) bool { return ds.Palettes[i].ID < ds.Palettes[j].ID })
+	for _, obj := range e.Objects {
+		ds.Objects = append(ds.Objects, obj)
+	}
+	sort.Slice(ds.Objects, func(i, j int) bool { return ds.Objects[i].ID < ds.Objects[j].ID })
+	ds.DecodingTime = t - pgs.DecodeDuration(&ds, pgs.NewEpoch())
+	if ds.DecodingTime < 0 {
+		ds.DecodingTime = 0
+	}
+	return ds
+}
+
+// clearScreen creates a Normal composition presented at t that clears
+// the windows of the epoch.
+func clearScreen(e *pgs.Epoch, t time.Duration) pgs.DisplaySet {
+	ds := pgs.DisplaySet{PresentationTime: t, PresentationComposition: e.Composition}
+	ds.CompositionState = pgs.Normal
+	ds.PaletteUpdate = false
+	ds.CompositionObjects = nil
+	for _, w := range e.Windows {
+		ds.Windows = append(ds.Windows, w)
+	}
+	sort.Slice(ds.Windows, func(i, j int) bool { return ds.Windows[i].ID < ds.Windows[j].ID })
+	ds.DecodingTime = t - pgs.DecodeDuration(&ds, e)
+	if ds.DecodingTime < 0 {
+		ds.DecodingTime = 0
+	}
+	return ds
+}
@@ -0,0 +1,107 @@
+package trans
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+	"github.com/andrewarchi/transup/pgs/pgstest"
+)
+
+// testStream returns an epoch that shows an object at 1s, fades it at
+// 2s, shows a new version at 3s, and clears it at 4s.
+func testStream(t *testing.T) []pgs.DisplaySet {
+	pix := bytes.Repeat([]uint8{1}, 64)
+	obj := pgstest.NewObject(t, 0, 0, 8, pix)
+	pix[0] = 0
+	obj2 := pgstest.NewObject(t, 0, 1, 8, pix)
+	pal, faded := pgstest.NewPalettes(t, color.NRGBA{255, 255, 255, 255})
+
+	pc := pgs.PresentationComposition{Width: 64, Height: 64}
+	co := []pgs.CompositionObject{{X: 4, Y: 4}}
+	stream := make([]pgs.DisplaySet, 4)
+	for i := range stream {
+		stream[i].PresentationTime = time.Duration(i+1) * time.Second
+		stream[i].DecodingTime = stream[i].PresentationTime
+		stream[i].PresentationComposition = pc
+		stream[i].CompositionNumber = uint16(i)
+	}
+	stream[0].CompositionState = pgs.EpochStart
+	stream[0].Windows = []pgs.Window{{X: 4, Y: 4, Width: 8, Height: 8}}
+	stream[0].Palettes = pgs.Palettes{pal}
+	stream[0].Objects = []pgs.Object{obj}
+	stream[0].CompositionObjects = co
+	stream[1].PaletteUpdate = true
+	stream[1].Palettes = pgs.Palettes{faded}
+	stream[1].CompositionObjects = co
+	stream[2].Objects = []pgs.Object{obj2}
+	stream[2].CompositionObjects = co
+	return stream
+}
+
+// sec returns a duration of s seconds.
+func sec(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func checkValid(t *testing.T, name string, stream []pgs.DisplaySet) {
+	t.Helper()
+	for _, f := range pgs.ValidateStream(stream) {
+		if f.Severity == pgs.Error {
+			t.Errorf("%s: %s", name, f)
+		}
+	}
+}
+
+func checkEvents(t *testing.T, name string, stream []pgs.DisplaySet, times ...time.Duration) {
+	t.Helper()
+	events, err := pgs.Events(stream, pgs.ColorSpace{})
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if len(events) != len(times)/2 {
+		t.Fatalf("%s: got %d events, want %d", name, len(events), len(times)/2)
+	}
+	for i, ev := range events {
+		if ev.Start != times[2*i] || ev.End != times[2*i+1] {
+			t.Errorf("%s: event %d from %s to %s, want %s to %s",
+				name, i, ev.Start, ev.End, times[2*i], times[2*i+1])
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	stream := testStream(t)
+
+	trimmed, err := Trim(stream, sec(2.5), sec(3.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trimmed) != 3 || trimmed[0].CompositionState != pgs.EpochStart {
+		t.Fatalf("got %d display sets, want an Epoch Start and 2 more", len(trimmed))
+	}
+	checkValid(t, "trimmed", trimmed)
+	checkEvents(t, "trimmed", trimmed, sec(2.5), sec(3), sec(3), sec(3.5))
+
+	// A cut after the clear still defines the epoch for what follows
+	trimmed, err = Trim(stream, sec(4.5), sec(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValid(t, "trimmed after clear", trimmed)
+	checkEvents(t, "trimmed after clear", trimmed)
+
+	joined, err := Concat(stream, trimmed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined, err = Concat(joined, stream, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValid(t, "joined", joined)
+	checkEvents(t, "joined", joined, sec(1), sec(2), sec(2), sec(3), sec(3), sec(4),
+		sec(6), sec(7), sec(7), sec(8), sec(8), sec(9))
+}
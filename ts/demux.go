@@ -0,0 +1,351 @@
+package ts
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Reader demuxes a PGS stream from a transport stream and reads it as
+// SUP, with a segment header for each segment in the PES packets.
+type Reader struct {
+	r        *bufio.Reader
+	off      int64  // Offset of the next packet
+	size     int    // Packet size, detected from the first packets
+	pid      uint16 // PID of the PGS stream, or 0 until found in the PMT
+	pmtPIDs  map[uint16]bool
+	streams  []Stream
+	sections map[uint16][]byte // PSI sections being reassembled
+	pes      []byte            // PES packet being reassembled
+	cc       int               // Continuity counter of the last packet, or -1
+	dts      pgs.Timestamp     // Decoding time of the last PES packet
+	pts      pgs.Timestamp     // Presentation time of the last PES packet
+	buf      bytes.Buffer      // SUP data not yet read
+	sw       *pgs.SegmentWriter
+	warnings []pgs.Repair
+	err      error
+}
+
+// NewReader creates a reader for the PGS stream with the PID, or for
+// the first PGS stream in the PMT if pid is 0.
+func NewReader(r io.Reader, pid uint16) *Reader {
+	tr := &Reader{
+		r:        bufio.NewReader(r),
+		pid:      pid,
+		pmtPIDs:  make(map[uint16]bool),
+		sections: make(map[uint16][]byte),
+		cc:       -1,
+	}
+	tr.sw = pgs.NewSegmentWriter(&tr.buf)
+	return tr
+}
+
+// Streams returns the PGS streams found in the PMT so far.
+func (r *Reader) Streams() []Stream {
+	return r.streams
+}
+
+// PID returns the PID of the PGS stream read, or 0 until it is found.
+func (r *Reader) PID() uint16 {
+	return r.pid
+}
+
+// Warnings returns the damage skipped so far, such as PES packets
+// dropped for continuity errors.
+func (r *Reader) Warnings() []pgs.Repair {
+	return r.warnings
+}
+
+// Read reads the SUP data of the PGS stream.
+func (r *Reader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.readPacket()
+		if r.err == io.EOF {
+			if len(r.pes) != 0 {
+				if err := r.flushPES(); err != nil {
+					r.err = err
+				}
+			}
+			if r.pid == 0 && r.err == io.EOF {
+				r.err = errors.New("no PGS stream in transport stream")
+			}
+		}
+	}
+	return r.buf.Read(p)
+}
+
+// detectSize detects whether packets have 188 or 192 bytes by the
+// position of the sync bytes of the first packets.
+func (r *Reader) detectSize() error {
+	b, err := r.r.Peek(3 * m2tsPacketSize)
+	if len(b) == 0 && err == io.EOF {
+		return io.EOF
+	}
+	for _, size := range []int{packetSize, m2tsPacketSize} {
+		off := size - packetSize
+		ok := true
+		for i := off; i < len(b); i += size {
+			if b[i] != syncByte {
+				ok = false
+				break
+			}
+		}
+		if ok && off < len(b) {
+			r.size = size
+			return nil
+		}
+	}
+	return errors.New("not a transport stream: sync byte not found")
+}
+
+func (r *Reader) readPacket() error {
+	if r.size == 0 {
+		if err := r.detectSize(); err != nil {
+			return err
+		}
+	}
+	off := r.off
+	buf := make([]byte, r.size)
+	n, err := io.ReadFull(r.r, buf)
+	r.off += int64(n)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("packet truncated at %d bytes", n)
+		}
+		return err
+	}
+	p := buf[r.size-packetSize:]
+	if p[0] != syncByte {
+		return fmt.Errorf("lost sync: 0x%02x instead of sync byte", p[0])
+	}
+	if p[1]&0x80 != 0 {
+		return nil // Transport error indicator
+	}
+	start := p[1]&0x40 != 0
+	pid := uint16(p[1]&0x1f)<<8 | uint16(p[2])
+	afc := p[3] >> 4 & 3
+	cc := int(p[3] & 0xf)
+	payload := p[4:]
+	if afc&2 != 0 {
+		if int(p[4]) >= len(payload) {
+			return nil // Adaptation field only
+		}
+		payload = payload[1+int(p[4]):]
+	}
+	if afc&1 == 0 {
+		return nil
+	}
+
+	switch {
+	case pid == patPID || r.pmtPIDs[pid]:
+		return r.readPSI(pid, start, payload)
+	case pid == r.pid && r.pid != 0:
+		if r.cc != -1 && cc == r.cc && !start {
+			return nil // Duplicate packet
+		}
+		if r.cc != -1 && cc != (r.cc+1)&0xf && !start && len(r.pes) != 0 {
+			// Drop the partial PES packet and resume at the next start
+			r.cc = cc
+			r.pes = nil
+			r.warnings = append(r.warnings, pgs.Repair{Offset: off,
+				Err: fmt.Errorf("continuity error on PID 0x%04x: dropped partial PES packet", pid)})
+			return nil
+		}
+		r.cc = cc
+		if start {
+			if len(r.pes) != 0 {
+				if err := r.flushPES(); err != nil {
+					return err
+				}
+			}
+		} else if len(r.pes) == 0 {
+			return nil // Continuation without start
+		}
+		r.pes = append(r.pes, payload...)
+		if len(r.pes) >= 6 {
+			if l := int(r.pes[4])<<8 | int(r.pes[5]); l != 0 && len(r.pes) >= 6+l {
+				return r.flushPES()
+			}
+		}
+	}
+	return nil
+}
+
+// readPSI reassembles PSI sections of the PAT and PMTs.
+func (r *Reader) readPSI(pid uint16, start bool, payload []byte) error {
+	if start {
+		if len(payload) == 0 || int(payload[0]) >= len(payload) {
+			return fmt.Errorf("PSI pointer field out of range on PID 0x%04x", pid)
+		}
+		payload = payload[1+int(payload[0]):]
+		r.sections[pid] = nil
+	} else if r.sections[pid] == nil {
+		return nil
+	}
+	sec := append(r.sections[pid], payload...)
+	r.sections[pid] = sec
+	for len(sec) >= 3 && sec[0] != 0xff {
+		l := 3 + (int(sec[1]&0x0f)<<8 | int(sec[2]))
+		if len(sec) < l {
+			return nil
+		}
+		if err := r.readSection(pid, sec[:l]); err != nil {
+			return err
+		}
+		sec = sec[l:]
+	}
+	delete(r.sections, pid)
+	return nil
+}
+
+func (r *Reader) readSection(pid uint16, sec []byte) error {
+	if len(sec) < 12 {
+		return fmt.Errorf("PSI section on PID 0x%04x truncated", pid)
+	}
+	if crc32(sec) != 0 {
+		return fmt.Errorf("PSI section on PID 0x%04x has bad CRC", pid)
+	}
+	body := sec[8 : len(sec)-4]
+	switch sec[0] {
+	case tablePAT:
+		for ; len(body) >= 4; body = body[4:] {
+			program := uint16(body[0])<<8 | uint16(body[1])
+			if program != 0 {
+				r.pmtPIDs[uint16(body[2]&0x1f)<<8|uint16(body[3])] = true
+			}
+		}
+	case tablePMT:
+		if len(body) < 4 {
+			return fmt.Errorf("PMT on PID 0x%04x truncated", pid)
+		}
+		info := int(body[2]&0x0f)<<8 | int(body[3])
+		if 4+info > len(body) {
+			return fmt.Errorf("PMT on PID 0x%04x truncated", pid)
+		}
+		for es := body[4+info:]; len(es) >= 5; {
+			typ := es[0]
+			espid := uint16(es[1]&0x1f)<<8 | uint16(es[2])
+			l := int(es[3]&0x0f)<<8 | int(es[4])
+			if 5+l > len(es) {
+				return fmt.Errorf("PMT on PID 0x%04x truncated", pid)
+			}
+			if typ == StreamTypePGS && !r.known(espid) {
+				s := Stream{PID: espid, Language: language(es[5 : 5+l])}
+				r.streams = append(r.streams, s)
+				if r.pid == 0 {
+					r.pid = espid
+				}
+			}
+			es = es[5+l:]
+		}
+	}
+	return nil
+}
+
+func (r *Reader) known(pid uint16) bool {
+	for _, s := range r.streams {
+		if s.PID == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// language returns the language code of an ISO 639 language descriptor
+// in the descriptors.
+func language(desc []byte) string {
+	for len(desc) >= 2 {
+		tag, l := desc[0], int(desc[1])
+		if 2+l > len(desc) {
+			break
+		}
+		if tag == descriptorLanguage && l >= 3 {
+			return string(desc[2:5])
+		}
+		desc = desc[2+l:]
+	}
+	return ""
+}
+
+// flushPES writes the segments of the reassembled PES packet with
+// headers synthesized from its timestamps. A PES packet without a
+// decoding time takes that of the preceding packet, when it has the
+// same presentation time, so that the segments of a display set, which
+// often omit it for END, have consistent headers.
+func (r *Reader) flushPES() error {
+	p := r.pes
+	r.pes = nil
+	if len(p) < 9 || p[0] != 0 || p[1] != 0 || p[2] != 1 {
+		return errors.New("PES packet has invalid start code")
+	}
+	if p[3] != streamIDPrivate1 {
+		return fmt.Errorf("PES stream ID 0x%02x not private stream 1", p[3])
+	}
+	if l := int(p[4])<<8 | int(p[5]); l != 0 {
+		if 6+l > len(p) {
+			return fmt.Errorf("PES packet has %d bytes, %d bytes declared", len(p)-6, l)
+		}
+		p = p[:6+l]
+	}
+	flags, hl := p[7], int(p[8])
+	if 9+hl > len(p) {
+		return errors.New("PES header truncated")
+	}
+	h := p[9 : 9+hl]
+	if flags&0x80 != 0 {
+		if len(h) < 5 {
+			return errors.New("PES header truncated")
+		}
+		pts := timestamp(h)
+		dts := pts
+		if flags&0x40 != 0 {
+			if len(h) < 10 {
+				return errors.New("PES header truncated")
+			}
+			dts = timestamp(h[5:])
+		} else if pts == r.pts {
+			dts = r.dts
+		}
+		r.pts, r.dts = pts, dts
+	}
+
+	for data := p[9+hl:]; len(data) != 0; {
+		if len(data) < 3 {
+			return errors.New("segment header truncated in PES packet")
+		}
+		size := int(data[1])<<8 | int(data[2])
+		if 3+size > len(data) {
+			return fmt.Errorf("%s segment truncated in PES packet", pgs.SegmentType(data[0]))
+		}
+		s := &pgs.Segment{
+			SegmentHeader: pgs.SegmentHeader{
+				MagicNumber:      pgs.MagicNumber,
+				PresentationTime: r.pts,
+				DecodingTime:     r.dts,
+				SegmentType:      pgs.SegmentType(data[0]),
+				SegmentSize:      uint16(size),
+			},
+			Data: data[3 : 3+size],
+		}
+		if err := r.sw.Write(s); err != nil {
+			return err
+		}
+		data = data[3+size:]
+	}
+	return nil
+}
+
+// timestamp decodes a 33-bit PES timestamp, truncated to the 32 bits of
+// segment headers.
+func timestamp(b []byte) pgs.Timestamp {
+	ts := uint64(b[0]>>1&7)<<30 | uint64(b[1])<<22 | uint64(b[2]>>1)<<15 |
+		uint64(b[3])<<7 | uint64(b[4]>>1)
+	return pgs.Timestamp(ts)
+}
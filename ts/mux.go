@@ -0,0 +1,304 @@
+package ts
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+const (
+	// DefaultPID is the PID of the first PGS stream on Blu-ray.
+	DefaultPID = 0x1200
+	// DefaultPMTPID is the PID of the PMT on Blu-ray.
+	DefaultPMTPID = 0x0100
+
+	descriptorRegistration = 0x05
+
+	// muxRate is the nominal rate in bits per second at which packets
+	// arrive, which spaces the arrival timestamps of M2TS packets.
+	muxRate = 48000000
+	// packetTicks is the time between packets at muxRate in 27 MHz ticks.
+	packetTicks = 27000000 * packetSize * 8 / muxRate
+)
+
+// MuxOptions configures the muxing of a PGS stream.
+type MuxOptions struct {
+	PID      uint16 // PID of the PGS stream, or DefaultPID if 0
+	PMTPID   uint16 // PID of the PMT, or DefaultPMTPID if 0
+	Language string // ISO 639-2 code, omitted from the PMT if empty
+	// M2TS selects 192-byte packets with 4-byte arrival timestamps, as in
+	// Blu-ray .m2ts files.
+	M2TS bool
+}
+
+// Mux writes the stream as a transport stream with a single program.
+// Each segment is carried in a PES packet with the times of its display
+// set, with objects fragmented further where a segment does not fit in
+// the length of the packet, and a PAT and PMT precede every Epoch Start
+// and Acquisition Point. The PGS stream carries the PCR, which follows
+// the arrival time of the packets, timed to arrive before the decoding
+// time. The options may be nil.
+func Mux(w io.Writer, stream []pgs.DisplaySet, opts *MuxOptions) error {
+	var o MuxOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.PID == 0 {
+		o.PID = DefaultPID
+	}
+	if o.PMTPID == 0 {
+		o.PMTPID = DefaultPMTPID
+	}
+	if o.Language != "" && len(o.Language) != 3 {
+		return fmt.Errorf("invalid language code: %q", o.Language)
+	}
+
+	bw := bufio.NewWriter(w)
+	m := &muxer{w: bw, opts: &o, cc: make(map[uint16]uint8)}
+	for i := range stream {
+		ds := &stream[i]
+		var sup bytes.Buffer
+		if err := pgs.NewWriter(&sup).Write(ds); err != nil {
+			return err
+		}
+		var pes [][]byte
+		sr := pgs.NewSegmentReader(&sup)
+		for {
+			s, err := sr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			segs, err := splitSegment(s)
+			if err != nil {
+				return fmt.Errorf("display set %d: %w", i, err)
+			}
+			for _, s := range segs {
+				pes = append(pes, newPES(s))
+			}
+		}
+
+		psi := i == 0 || ds.CompositionState != pgs.Normal
+		var n uint64
+		if psi {
+			n += 2
+		}
+		for _, p := range pes {
+			n += packets(len(p))
+		}
+		// Arrive before the decoding time
+		if dts, d := 300*uint64(pgs.NewTimestamp(ds.DecodingTime)), n*packetTicks; dts > d {
+			m.atc = maxUint64(m.atc, dts-d)
+		}
+		if psi {
+			if err := m.writePSI(); err != nil {
+				return err
+			}
+		}
+		for _, p := range pes {
+			if err := m.writePES(o.PID, p); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+type muxer struct {
+	w    *bufio.Writer
+	opts *MuxOptions
+	cc   map[uint16]uint8 // Continuity counters by PID
+	atc  uint64           // Arrival time of the next packet in 27 MHz ticks
+}
+
+// maxPESSegment is the longest segment payload that fits in a PES
+// packet with both timestamps.
+const maxPESSegment = 0xffff - 13 - 3
+
+// splitSegment splits an ODS segment too long for a PES packet into
+// shorter fragments of the same object. Other segments are never that
+// long.
+func splitSegment(s *pgs.Segment) ([]*pgs.Segment, error) {
+	if len(s.Data) <= maxPESSegment {
+		return []*pgs.Segment{s}, nil
+	}
+	if s.SegmentType != pgs.ODSType {
+		return nil, fmt.Errorf("%s segment of %d bytes too long for a PES packet", s.SegmentType, len(s.Data))
+	}
+	f, err := s.ObjectFragment()
+	if err != nil {
+		return nil, err
+	}
+	var segs []*pgs.Segment
+	head, data := s.Data[:len(s.Data)-len(f.Data)], f.Data
+	for len(data) != 0 {
+		// The first keeps the header, with the length and dimensions when
+		// first in the sequence, and the last keeps the last flag
+		b := append([]byte{}, head...)
+		b[3] &^= 0x40
+		n := len(data)
+		if n > maxPESSegment-len(b) {
+			n = maxPESSegment - len(b)
+		}
+		b = append(b, data[:n]...)
+		if data = data[n:]; len(data) == 0 && f.Last {
+			b[3] |= 0x40
+		}
+		seg := *s
+		seg.SegmentSize, seg.Data = uint16(len(b)), b
+		segs = append(segs, &seg)
+		head = []byte{s.Data[0], s.Data[1], s.Data[2], 0}
+	}
+	return segs, nil
+}
+
+// newPES creates a PES packet for the segment, without its segment
+// header. The decoding time is omitted when it equals the presentation
+// time. The segment must fit in the length of the packet.
+func newPES(s *pgs.Segment) []byte {
+	pts, dts := uint64(s.PresentationTime), uint64(s.DecodingTime)
+	p := []byte{0, 0, 1, streamIDPrivate1, 0, 0, 0x80, 0x80, 5}
+	if dts != pts {
+		p[7], p[8] = 0xc0, 10
+		p = appendTimestamp(p, 3, pts)
+		p = appendTimestamp(p, 1, dts)
+	} else {
+		p = appendTimestamp(p, 2, pts)
+	}
+	p = append(p, byte(s.SegmentType), byte(s.SegmentSize>>8), byte(s.SegmentSize))
+	p = append(p, s.Data...)
+	l := len(p) - 6
+	p[4], p[5] = byte(l>>8), byte(l)
+	return p
+}
+
+// appendTimestamp appends a 33-bit PES timestamp with the 4-bit prefix.
+func appendTimestamp(b []byte, prefix uint8, ts uint64) []byte {
+	return append(b,
+		prefix<<4|uint8(ts>>29)&0x0e|1,
+		uint8(ts>>22),
+		uint8(ts>>14)|1,
+		uint8(ts>>7),
+		uint8(ts<<1)|1)
+}
+
+// packets returns the number of packets of a PES packet of length l.
+func packets(l int) uint64 {
+	const first = packetSize - 4 - 8 // After the PCR
+	if l <= first {
+		return 1
+	}
+	return 1 + uint64(l-first+packetSize-4-1)/(packetSize-4)
+}
+
+// writePES writes the PES packet in packets on the PID, with the PCR in
+// the first.
+func (m *muxer) writePES(pid uint16, pes []byte) error {
+	for start := true; start || len(pes) != 0; start = false {
+		var af []byte
+		if start {
+			base, ext := m.atc/300, m.atc%300
+			af = []byte{0x10,
+				uint8(base >> 25), uint8(base >> 17), uint8(base >> 9), uint8(base >> 1),
+				uint8(base<<7) | 0x7e | uint8(ext>>8), uint8(ext)}
+		}
+		n, err := m.writePacket(pid, start, af, pes)
+		if err != nil {
+			return err
+		}
+		pes = pes[n:]
+	}
+	return nil
+}
+
+// writePSI writes the PAT and PMT.
+func (m *muxer) writePSI() error {
+	pat := section(tablePAT, 1, []byte{
+		0, 1, 0xe0 | uint8(m.opts.PMTPID>>8), uint8(m.opts.PMTPID)})
+	if _, err := m.writePacket(patPID, true, nil, append([]byte{0}, pat...)); err != nil {
+		return err
+	}
+
+	pid := m.opts.PID
+	es := []byte{StreamTypePGS, 0xe0 | uint8(pid>>8), uint8(pid), 0xf0, 0}
+	if lang := m.opts.Language; lang != "" {
+		es = append(es, descriptorLanguage, 4)
+		es = append(es, lang...)
+		es = append(es, 0)
+		es[4] = uint8(len(es) - 5)
+	}
+	pmt := section(tablePMT, 1, append([]byte{
+		0xe0 | uint8(pid>>8), uint8(pid), // PCR PID
+		0xf0, 6, descriptorRegistration, 4, 'H', 'D', 'M', 'V',
+	}, es...))
+	_, err := m.writePacket(m.opts.PMTPID, true, nil, append([]byte{0}, pmt...))
+	return err
+}
+
+// section creates a PSI section with the table ID extension, such as
+// the transport stream ID or program number, and its CRC.
+func section(table uint8, ext uint16, body []byte) []byte {
+	l := 5 + len(body) + 4
+	sec := append([]byte{table, 0xb0 | uint8(l>>8), uint8(l),
+		uint8(ext >> 8), uint8(ext), 0xc1, 0, 0}, body...)
+	crc := crc32(sec)
+	return append(sec, uint8(crc>>24), uint8(crc>>16), uint8(crc>>8), uint8(crc))
+}
+
+// writePacket writes a packet with as much of the payload as fits after
+// the adaptation field, stuffing the rest, and returns the
+// length of the payload written.
+func (m *muxer) writePacket(pid uint16, start bool, af, payload []byte) (int, error) {
+	var b [m2tsPacketSize]byte
+	p := b[:0]
+	if m.opts.M2TS {
+		ats := uint32(m.atc) & 0x3fffffff
+		p = append(p, uint8(ats>>24), uint8(ats>>16), uint8(ats>>8), uint8(ats))
+	}
+	cc := m.cc[pid]
+	m.cc[pid] = (cc + 1) & 0xf
+	m.atc += packetTicks
+	h := []byte{syncByte, uint8(pid>>8) & 0x1f, uint8(pid), 0x10 | cc}
+	if start {
+		h[1] |= 0x40
+	}
+
+	room := packetSize - 4
+	if af != nil {
+		room -= 1 + len(af)
+	}
+	n := len(payload)
+	if n > room {
+		n = room
+	}
+	if stuff := room - n; stuff != 0 || af != nil {
+		h[3] |= 0x20
+		if af == nil {
+			// The length, then the flags, take the first bytes
+			af = []byte{}
+			if stuff--; stuff != 0 {
+				af = append(af, 0)
+				stuff--
+			}
+		}
+		af = append(af, bytes.Repeat([]byte{0xff}, stuff)...)
+		h = append(h, uint8(len(af)))
+		h = append(h, af...)
+	}
+	p = append(p, h...)
+	p = append(p, payload[:n]...)
+	_, err := m.w.Write(p)
+	return n, err
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,53 @@
+// Package ts demuxes and muxes PGS streams in MPEG-2 transport streams,
+// including the 192-byte packets of Blu-ray .m2ts files.
+package ts
+
+import "fmt"
+
+const (
+	syncByte       = 0x47
+	packetSize     = 188
+	m2tsPacketSize = 192 // Packets prefixed with a 4-byte arrival timestamp
+
+	patPID = 0x0000
+
+	tablePAT = 0x00
+	tablePMT = 0x02
+
+	// StreamTypePGS is the PMT stream type of Presentation Graphic
+	// Streams.
+	StreamTypePGS = 0x90
+	// streamIDPrivate1 is the PES stream ID of PGS streams.
+	streamIDPrivate1 = 0xbd
+
+	descriptorLanguage = 0x0a // ISO 639 language descriptor
+)
+
+// Stream is a PGS elementary stream in a transport stream.
+type Stream struct {
+	PID      uint16
+	Language string // ISO 639-2 code, if given in the PMT
+}
+
+func (s Stream) String() string {
+	if s.Language == "" {
+		return fmt.Sprintf("PID 0x%04x", s.PID)
+	}
+	return fmt.Sprintf("PID 0x%04x (%s)", s.PID, s.Language)
+}
+
+// crc32 computes the CRC-32/MPEG-2 of PSI sections.
+func crc32(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
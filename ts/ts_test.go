@@ -0,0 +1,249 @@
+package ts
+
+import (
+	"bytes"
+	"image/color"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+var testStream = []pgs.DisplaySet{{
+	PresentationTime: 10 * time.Second,
+	DecodingTime:     10*time.Second - 50*time.Millisecond,
+	PresentationComposition: pgs.PresentationComposition{
+		Width:            1920,
+		Height:           1080,
+		FrameRate:        pgs.FrameRate23976,
+		CompositionState: pgs.EpochStart,
+		CompositionObjects: []pgs.CompositionObject{{
+			ObjectID: 0,
+			X:        100,
+			Y:        900,
+		}},
+	},
+	Windows: []pgs.Window{{X: 100, Y: 900, Width: 400, Height: 100}},
+	Palettes: pgs.Palettes{{Entries: []pgs.PaletteEntry{
+		{ID: 1, NYCbCrA: color.NYCbCrA{YCbCr: color.YCbCr{Y: 235, Cb: 128, Cr: 128}, A: 255}},
+	}}},
+	Objects: []pgs.Object{{ID: 0, Image: pgs.Image{Width: 400, Height: 100,
+		Data: bytes.Repeat([]byte{0, 0x41, 0x90, 0, 0}, 100)}}},
+}, {
+	PresentationTime: 12 * time.Second,
+	DecodingTime:     12 * time.Second,
+	PresentationComposition: pgs.PresentationComposition{
+		Width:             1920,
+		Height:            1080,
+		FrameRate:         pgs.FrameRate23976,
+		CompositionNumber: 1,
+	},
+	Windows: []pgs.Window{{X: 100, Y: 900, Width: 400, Height: 100}},
+}}
+
+// testPackets packetizes a payload on the PID, stuffing the last packet
+// with an adaptation field.
+func testPackets(pid uint16, cc *uint8, payload []byte) []byte {
+	var out []byte
+	for start := true; start || len(payload) != 0; start = false {
+		p := []byte{syncByte, byte(pid >> 8), byte(pid), 0x10 | *cc&0xf}
+		if start {
+			p[1] |= 0x40
+		}
+		*cc++
+		n := len(payload)
+		if n > packetSize-4 {
+			n = packetSize - 4
+		}
+		if stuff := packetSize - 4 - n; stuff != 0 {
+			p[3] |= 0x20
+			p = append(p, byte(stuff-1))
+			if stuff > 1 {
+				p = append(p, 0)
+				p = append(p, bytes.Repeat([]byte{0xff}, stuff-2)...)
+			}
+		}
+		out = append(out, append(p, payload[:n]...)...)
+		payload = payload[n:]
+	}
+	return out
+}
+
+func testSection(table uint8, body []byte) []byte {
+	l := 5 + len(body) + 4
+	sec := append([]byte{table, 0xb0 | byte(l>>8), byte(l), 0, 1, 0xc1, 0, 0}, body...)
+	crc := crc32(sec)
+	return append(sec, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+func testPES(pts, dts pgs.Timestamp, hasDTS bool, data []byte) []byte {
+	h := []byte{0, 0, 1, streamIDPrivate1, 0, 0, 0x81, 0x80, 5}
+	ts := func(prefix byte, t pgs.Timestamp) []byte {
+		v := uint64(t)
+		return []byte{prefix<<4 | byte(v>>29)&0xe | 1, byte(v >> 22), byte(v>>14) | 1, byte(v >> 7), byte(v<<1) | 1}
+	}
+	if hasDTS {
+		h[7], h[8] = 0xc0, 10
+		h = append(h, ts(3, pts)...)
+		h = append(h, ts(1, dts)...)
+	} else {
+		h = append(h, ts(2, pts)...)
+	}
+	h = append(h, data...)
+	l := len(h) - 6
+	h[4], h[5] = byte(l>>8), byte(l)
+	return h
+}
+
+// testTS muxes the SUP data into a transport stream with a PAT and a
+// PMT, with a PES packet for each segment. If drop is set, the second
+// packet of the first multi-packet PES packet is dropped.
+func testTS(sup []byte, drop bool) []byte {
+	const pmtPID, pgsPID = 0x100, 0x1200
+	var ccPAT, ccPMT, ccPGS uint8
+	var tsData []byte
+	tsData = append(tsData, testPackets(patPID, &ccPAT, append([]byte{0},
+		testSection(tablePAT, []byte{0, 1, 0xe0 | pmtPID>>8, pmtPID & 0xff})...))...)
+	tsData = append(tsData, testPackets(pmtPID, &ccPMT, append([]byte{0},
+		testSection(tablePMT, []byte{0xe1, 0x01, 0xf0, 0,
+			StreamTypePGS, 0xe0 | pgsPID>>8, pgsPID & 0xff, 0xf0, 6,
+			descriptorLanguage, 4, 'e', 'n', 'g', 0})...))...)
+	sr := pgs.NewSegmentReader(bytes.NewReader(sup))
+	for {
+		s, err := sr.Read()
+		if err != nil {
+			break
+		}
+		seg := append([]byte{byte(s.SegmentType), byte(s.SegmentSize >> 8), byte(s.SegmentSize)}, s.Data...)
+		// END segments omit the decoding time, as on Blu-ray
+		hasDTS := s.SegmentType != pgs.ENDType
+		packets := testPackets(pgsPID, &ccPGS, testPES(s.PresentationTime, s.DecodingTime, hasDTS, seg))
+		if drop && len(packets) > 2*packetSize {
+			packets = append(packets[:packetSize:packetSize], packets[2*packetSize:]...)
+			drop = false
+		}
+		tsData = append(tsData, packets...)
+	}
+	return tsData
+}
+
+func TestReader(t *testing.T) {
+	var sup bytes.Buffer
+	if err := pgs.NewWriter(&sup).WriteAll(testStream); err != nil {
+		t.Fatal(err)
+	}
+	tsData := testTS(sup.Bytes(), false)
+	m2tsData := make([]byte, 0, len(tsData)/packetSize*m2tsPacketSize)
+	for i := 0; i < len(tsData); i += packetSize {
+		m2tsData = append(m2tsData, 0, 0, 0, 0)
+		m2tsData = append(m2tsData, tsData[i:i+packetSize]...)
+	}
+
+	for _, data := range [][]byte{tsData, m2tsData} {
+		r := NewReader(bytes.NewReader(data), 0)
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, sup.Bytes()) {
+			t.Errorf("demuxed SUP differs:\ngot  %x\nwant %x", got, sup.Bytes())
+		}
+		want := []Stream{{PID: 0x1200, Language: "eng"}}
+		if !reflect.DeepEqual(r.Streams(), want) {
+			t.Errorf("got streams %v, want %v", r.Streams(), want)
+		}
+	}
+}
+
+// TestReaderContinuity checks that a lost packet drops only its PES
+// packet and demuxing continues.
+func TestReaderContinuity(t *testing.T) {
+	var sup, rest bytes.Buffer
+	if err := pgs.NewWriter(&sup).WriteAll(testStream); err != nil {
+		t.Fatal(err)
+	}
+	if err := pgs.NewWriter(&rest).WriteAll(testStream[1:]); err != nil {
+		t.Fatal(err)
+	}
+	r := NewReader(bytes.NewReader(testTS(sup.Bytes(), true)), 0)
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Warnings()) != 1 {
+		t.Errorf("got warnings %v, want 1", r.Warnings())
+	}
+	if !bytes.HasSuffix(got, rest.Bytes()) || len(got) >= len(sup.Bytes()) {
+		t.Errorf("demuxed SUP %x does not end with the display set after the damage", got)
+	}
+}
+
+func TestMuxRoundTrip(t *testing.T) {
+	var sup bytes.Buffer
+	if err := pgs.NewWriter(&sup).WriteAll(testStream); err != nil {
+		t.Fatal(err)
+	}
+	for _, m2ts := range []bool{false, true} {
+		var data bytes.Buffer
+		opts := &MuxOptions{Language: "fra", M2TS: m2ts}
+		if err := Mux(&data, testStream, opts); err != nil {
+			t.Fatal(err)
+		}
+		size := packetSize
+		if m2ts {
+			size = m2tsPacketSize
+		}
+		if data.Len()%size != 0 {
+			t.Errorf("muxed length %d not a multiple of %d", data.Len(), size)
+		}
+		r := NewReader(&data, 0)
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, sup.Bytes()) {
+			t.Errorf("demuxed SUP differs:\ngot  %x\nwant %x", got, sup.Bytes())
+		}
+		want := []Stream{{PID: DefaultPID, Language: "fra"}}
+		if !reflect.DeepEqual(r.Streams(), want) {
+			t.Errorf("got streams %v, want %v", r.Streams(), want)
+		}
+	}
+}
+
+func TestMuxLargeObject(t *testing.T) {
+	line := append(bytes.Repeat([]byte{1}, 1000), 0, 0)
+	stream := append([]pgs.DisplaySet{}, testStream...)
+	stream[0].Objects = []pgs.Object{{ID: 0, Image: pgs.Image{Width: 1000, Height: 150,
+		Data: bytes.Repeat(line, 150)}}}
+	var data bytes.Buffer
+	if err := Mux(&data, stream, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Private stream 1 must not have unbounded PES packets
+	for b := data.Bytes(); len(b) >= packetSize; b = b[packetSize:] {
+		p := b[:packetSize]
+		if p[1]&0x40 == 0 || uint16(p[1]&0x1f)<<8|uint16(p[2]) != DefaultPID {
+			continue
+		}
+		pes := p[4:]
+		if p[3]&0x20 != 0 {
+			pes = pes[1+int(p[4]):]
+		}
+		if pes[4] == 0 && pes[5] == 0 {
+			t.Error("PES packet has no length")
+		}
+	}
+	got, err := pgs.NewReader(NewReader(bytes.NewReader(data.Bytes()), 0)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(stream) {
+		t.Fatalf("got %d display sets, want %d", len(got), len(stream))
+	}
+	if !reflect.DeepEqual(got[0].Objects, stream[0].Objects) {
+		t.Error("demuxed object differs")
+	}
+}
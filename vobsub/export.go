@@ -0,0 +1,174 @@
+package vobsub
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Options configures the conversion of subtitles to VobSub.
+type Options struct {
+	// Size of the video. Subtitles are scaled from the size of the
+	// stream, when different.
+	Width, Height int
+	// Palette of 16 colors in the index. DefaultPalette is used if nil.
+	Palette color.Palette
+	// Palette indices of the background, pattern, emphasis 1, and
+	// emphasis 2 colors of every subtitle. If nil, each subtitle is
+	// reduced to three colors and the nearest palette entry to each is
+	// used.
+	Colors *[4]uint8
+	// Language code of the track, which is "--" if empty.
+	Language string
+}
+
+// Export writes the subtitles as a VobSub .idx file at filename and a
+// .sub file alongside it. The subtitles are positioned on a screen of
+// the given size.
+func Export(filename string, subs []pgs.Subtitle, screen image.Point, opts *Options) error {
+	base := strings.TrimSuffix(filename, ".idx")
+	idx, err := os.Create(base + ".idx")
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+	sub, err := os.Create(base + ".sub")
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	if err := Encode(idx, sub, subs, screen, opts); err != nil {
+		return err
+	}
+	if err := sub.Close(); err != nil {
+		return err
+	}
+	return idx.Close()
+}
+
+// Encode writes the subtitles as VobSub, with the index to idx and the
+// SPU packets to sub. The subtitles are positioned on a screen of the
+// given size. The options may be nil.
+func Encode(idx, sub io.Writer, subs []pgs.Subtitle, screen image.Point, opts *Options) error {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Width == 0 || o.Height == 0 {
+		o.Width, o.Height = screen.X, screen.Y
+	}
+	if o.Palette == nil {
+		o.Palette = DefaultPalette
+	}
+	if len(o.Palette) != 16 {
+		return fmt.Errorf("palette has %d colors, 16 required", len(o.Palette))
+	}
+	if o.Language == "" {
+		o.Language = "--"
+	}
+
+	iw := bufio.NewWriter(idx)
+	fmt.Fprintln(iw, "# VobSub index file, v7 (do not modify this line!)")
+	fmt.Fprintf(iw, "size: %dx%d\n", o.Width, o.Height)
+	fmt.Fprintln(iw, "org: 0, 0")
+	fmt.Fprintln(iw, "scale: 100%, 100%")
+	fmt.Fprintln(iw, "alpha: 100%")
+	fmt.Fprintln(iw, "smooth: OFF")
+	fmt.Fprintln(iw, "fadein/out: 0, 0")
+	fmt.Fprintln(iw, "align: OFF at LEFT TOP")
+	fmt.Fprintln(iw, "time offset: 0")
+	fmt.Fprintln(iw, "forced subs: OFF")
+	fmt.Fprint(iw, "palette: ")
+	for i, c := range o.Palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		if i != 0 {
+			fmt.Fprint(iw, ", ")
+		}
+		fmt.Fprintf(iw, "%02x%02x%02x", n.R, n.G, n.B)
+	}
+	fmt.Fprintln(iw)
+	fmt.Fprintln(iw, "custom colors: OFF, tridx: 0000, colors: 000000, 000000, 000000, 000000")
+	fmt.Fprintln(iw, "langidx: 0")
+	fmt.Fprintf(iw, "id: %s, index: 0\n", o.Language)
+
+	cw := &countWriter{w: sub}
+	for i, s := range subs {
+		u, err := newSPU(&s, screen, &o)
+		if err != nil {
+			return fmt.Errorf("subtitle %d: %w", i+1, err)
+		}
+		data, err := u.encode()
+		if err != nil {
+			return fmt.Errorf("subtitle %d: %w", i+1, err)
+		}
+		fmt.Fprintf(iw, "timestamp: %s, filepos: %09x\n", timestamp(s.In), cw.n)
+		if err := writePacks(cw, data, uint64(pgs.NewTimestamp(s.In))); err != nil {
+			return fmt.Errorf("subtitle %d: %w", i+1, err)
+		}
+	}
+	return iw.Flush()
+}
+
+// newSPU scales the subtitle to the video size and reduces it to the
+// four colors of an SPU.
+func newSPU(s *pgs.Subtitle, screen image.Point, o *Options) (*spu, error) {
+	scaled := scaleSubtitle(s, screen, image.Pt(o.Width, o.Height))
+	img := scaled.Image
+
+	// Order the colors as pattern, emphasis 1, and emphasis 2, by
+	// brightest, darkest, then the middle
+	colors := pgs.Quantize(img, 3)
+	sort.SliceStable(colors, func(i, j int) bool {
+		return luma(colors[i]) > luma(colors[j])
+	})
+	if len(colors) == 3 {
+		colors[1], colors[2] = colors[2], colors[1]
+	}
+	p := append(color.Palette{color.Transparent}, colors...)
+
+	u := &spu{
+		X:     scaled.X,
+		Y:     scaled.Y,
+		Image: pgs.Paletted(img, p),
+	}
+	if s.Out > s.In {
+		u.Duration = s.Out - s.In
+	}
+	if o.Colors != nil {
+		u.Colors = *o.Colors
+	}
+	for i, c := range p[1:] {
+		n := c.(color.NRGBA)
+		u.Alpha[i+1] = uint8((int(n.A)*15 + 127) / 255)
+		if o.Colors == nil {
+			n.A = 0xff
+			u.Colors[i+1] = uint8(o.Palette.Index(n))
+		}
+	}
+	return u, nil
+}
+
+// luma returns the relative luminance of a color.
+func luma(c color.Color) int {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return 299*int(n.R) + 587*int(n.G) + 114*int(n.B)
+}
+
+// countWriter counts the bytes written.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,203 @@
+package vobsub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Track is a subtitle track of a VobSub file.
+type Track struct {
+	Language  string
+	Index     int
+	Screen    image.Point // Size of the video
+	Subtitles []pgs.Subtitle
+}
+
+// Import reads a subtitle track from a VobSub .idx file and the .sub
+// file alongside it. A negative index selects the track of langidx.
+func Import(filename string, index int) (*Track, error) {
+	base := strings.TrimSuffix(filename, ".idx")
+	sub, err := ioutil.ReadFile(base + ".sub")
+	if err != nil {
+		return nil, err
+	}
+	idx, err := os.Open(base + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	defer idx.Close()
+	return Decode(idx, sub, index)
+}
+
+type idxEntry struct {
+	line int
+	time time.Duration
+	pos  int64
+}
+
+// Decode reads a subtitle track from a VobSub index and the contents of
+// the .sub file. A negative index selects the track of langidx. Each
+// SPU is rendered with the colors of the index palette.
+func Decode(idx io.Reader, sub []byte, index int) (*Track, error) {
+	var screen image.Point
+	var palette [16]color.NRGBA
+	var offset time.Duration
+	langidx := 0
+	languages := make(map[int]string)
+	entries := make(map[int][]idxEntry)
+	track := -1
+	var delay time.Duration
+
+	sc := bufio.NewScanner(idx)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		i := strings.IndexByte(text, ':')
+		if i == -1 {
+			return nil, fmt.Errorf("idx line %d: no key", line)
+		}
+		key, val := text[:i], strings.TrimSpace(text[i+1:])
+		var err error
+		switch key {
+		case "size":
+			if n, e := fmt.Sscanf(val, "%dx%d", &screen.X, &screen.Y); e != nil || n != 2 {
+				err = fmt.Errorf("invalid size: %q", val)
+			}
+		case "palette":
+			colors := strings.Split(val, ",")
+			if len(colors) != 16 {
+				err = fmt.Errorf("palette has %d colors, 16 required", len(colors))
+				break
+			}
+			for j, c := range colors {
+				rgb, e := strconv.ParseUint(strings.TrimSpace(c), 16, 24)
+				if e != nil {
+					err = fmt.Errorf("invalid palette color: %q", c)
+					break
+				}
+				palette[j] = color.NRGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 0xff}
+			}
+		case "time offset":
+			var ms int64
+			ms, err = strconv.ParseInt(val, 10, 64)
+			offset = time.Duration(ms) * time.Millisecond
+		case "custom colors":
+			if strings.HasPrefix(val, "ON") {
+				err = errors.New("custom colors not supported")
+			}
+		case "langidx":
+			langidx, err = strconv.Atoi(val)
+		case "id":
+			var lang string
+			if n, e := fmt.Sscanf(val, "%s index: %d", &lang, &track); e != nil || n != 2 {
+				err = fmt.Errorf("invalid id: %q", val)
+			}
+			languages[track] = strings.TrimSuffix(lang, ",")
+			delay = 0
+		case "delay":
+			delay, err = parseTimestamp(val)
+		case "timestamp":
+			var ts, pos string
+			if n, e := fmt.Sscanf(val, "%s filepos: %s", &ts, &pos); e != nil || n != 2 {
+				err = fmt.Errorf("invalid timestamp: %q", val)
+				break
+			}
+			if track == -1 {
+				err = errors.New("timestamp before id")
+				break
+			}
+			e := idxEntry{line: line}
+			if e.time, err = parseTimestamp(strings.TrimSuffix(ts, ",")); err != nil {
+				break
+			}
+			if e.pos, err = strconv.ParseInt(pos, 16, 64); err != nil {
+				break
+			}
+			e.time += offset + delay
+			entries[track] = append(entries[track], e)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("idx line %d: %w", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if index < 0 {
+		index = langidx
+	}
+	lang, ok := languages[index]
+	if !ok {
+		return nil, fmt.Errorf("track %d not in index", index)
+	}
+	if screen.X <= 0 || screen.Y <= 0 {
+		return nil, fmt.Errorf("invalid screen size: %dx%d", screen.X, screen.Y)
+	}
+
+	t := &Track{Language: lang, Index: index, Screen: screen}
+	for _, e := range entries[index] {
+		data, err := readSPU(sub, e.pos, substreamSub+uint8(index))
+		if err != nil {
+			return nil, fmt.Errorf("idx line %d: %w", e.line, err)
+		}
+		u, start, err := decodeSPU(data)
+		if err != nil {
+			return nil, fmt.Errorf("idx line %d: SPU at 0x%x: %w", e.line, e.pos, err)
+		}
+		s := pgs.Subtitle{
+			In:    e.time + start,
+			X:     u.X,
+			Y:     u.Y,
+			Image: u.render(&palette),
+		}
+		if u.Duration != 0 {
+			s.Out = s.In + u.Duration
+		}
+		t.Subtitles = append(t.Subtitles, s)
+	}
+	return t, nil
+}
+
+// render converts the SPU to an image with the colors of the palette.
+func (s *spu) render(palette *[16]color.NRGBA) *image.NRGBA {
+	var colors [4]color.NRGBA
+	for i := range colors {
+		colors[i] = palette[s.Colors[i]]
+		colors[i].A = s.Alpha[i] * 0x11
+	}
+	img := image.NewNRGBA(s.Image.Rect)
+	for i, v := range s.Image.Pix {
+		c := colors[v&3]
+		img.Pix[4*i], img.Pix[4*i+1], img.Pix[4*i+2], img.Pix[4*i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+// parseTimestamp parses a time in an .idx file, as HH:MM:SS:mmm with an
+// optional sign.
+func parseTimestamp(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	}
+	var h, m, sec, ms int64
+	if n, err := fmt.Sscanf(s, "%d:%d:%d:%d", &h, &m, &sec, &ms); err != nil || n != 4 {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
+	d := time.Duration(((h*60+m)*60+sec)*1000+ms) * time.Millisecond
+	return sign * d, nil
+}
@@ -0,0 +1,128 @@
+package vobsub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MPEG program stream framing of SPU packets in .sub files
+const (
+	packSize       = 0x800 // Size of each pack
+	packHeaderSize = 14
+	streamIDSub    = 0xbd // Private stream 1
+	streamIDPad    = 0xbe // Padding stream
+	substreamSub   = 0x20 // First subtitle substream
+	muxRate        = 25200
+)
+
+// writePacks writes an SPU as a sequence of PES packets of private
+// stream 1, one per pack, with the presentation time in the first.
+func writePacks(w io.Writer, spu []byte, pts uint64) error {
+	if pts >= 1<<33 {
+		return errors.New("presentation time out of range")
+	}
+	for first := true; first || len(spu) != 0; first = false {
+		pack := make([]byte, 0, packSize)
+		pack = appendPackHeader(pack, pts)
+
+		hdr := []byte{0x81, 0x00, 0x00} // MPEG-2 PES header flags
+		if first {
+			hdr = []byte{0x81, 0x80, 0x05,
+				0x21 | uint8(pts>>29)&0x0e, uint8(pts >> 22), uint8(pts>>14) | 1, uint8(pts >> 7), uint8(pts<<1) | 1}
+		}
+		room := packSize - len(pack) - 6 - len(hdr) - 1
+		n := len(spu)
+		pad := 0
+		if n >= room {
+			n = room
+		} else if pad = room - n; pad < 6 {
+			// Too little space for a padding packet, so stuff the header
+			for i := 0; i < pad; i++ {
+				hdr = append(hdr, 0xff)
+			}
+			hdr[2] += uint8(pad)
+			pad = 0
+		}
+		l := len(hdr) + 1 + n
+		pack = append(pack, 0, 0, 1, streamIDSub, uint8(l>>8), uint8(l))
+		pack = append(pack, hdr...)
+		pack = append(pack, substreamSub)
+		pack = append(pack, spu[:n]...)
+		spu = spu[n:]
+		if pad != 0 {
+			l := pad - 6
+			pack = append(pack, 0, 0, 1, streamIDPad, uint8(l>>8), uint8(l))
+			for len(pack) < packSize {
+				pack = append(pack, 0xff)
+			}
+		}
+		if _, err := w.Write(pack); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendPackHeader appends an MPEG-2 pack header with the system clock
+// reference.
+func appendPackHeader(b []byte, scr uint64) []byte {
+	return append(b, 0, 0, 1, 0xba,
+		0x44|uint8(scr>>27)&0x38|uint8(scr>>28)&0x03,
+		uint8(scr>>20),
+		uint8(scr>>12)&0xf8|0x04|uint8(scr>>13)&0x03,
+		uint8(scr>>5),
+		uint8(scr<<3)&0xf8|0x04,
+		0x01,
+		uint8(muxRate>>14), uint8(muxRate>>6&0xff), uint8(muxRate<<2&0xff)|0x03,
+		0xf8)
+}
+
+// readSPU reassembles the SPU of the subtitle substream from the PES
+// packets starting in the pack at pos.
+func readSPU(b []byte, pos int64, substream uint8) ([]byte, error) {
+	var spu []byte
+	size := -1
+	for i := pos; size < 0 || len(spu) < size; {
+		if i+4 > int64(len(b)) {
+			return nil, fmt.Errorf("SPU at 0x%x truncated", pos)
+		}
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			return nil, fmt.Errorf("no start code at 0x%x", i)
+		}
+		switch id := b[i+3]; id {
+		case 0xba:
+			if i+packHeaderSize > int64(len(b)) {
+				return nil, fmt.Errorf("pack header at 0x%x truncated", i)
+			}
+			if b[i+4]>>6 != 1 {
+				return nil, fmt.Errorf("pack header at 0x%x not MPEG-2", i)
+			}
+			i += packHeaderSize + int64(b[i+13]&7)
+		case 0xb9:
+			return nil, fmt.Errorf("SPU at 0x%x not ended before end of stream", pos)
+		default:
+			if i+6 > int64(len(b)) {
+				return nil, fmt.Errorf("PES header at 0x%x truncated", i)
+			}
+			end := i + 6 + (int64(b[i+4])<<8 | int64(b[i+5]))
+			if end > int64(len(b)) {
+				return nil, fmt.Errorf("PES packet at 0x%x truncated", i)
+			}
+			if id == streamIDSub {
+				p := b[i+6 : end]
+				if len(p) < 3 || p[0]>>6 != 2 || 3+int(p[2]) >= len(p) {
+					return nil, fmt.Errorf("PES packet at 0x%x has invalid header", i)
+				}
+				if data := p[3+int(p[2]):]; data[0] == substream {
+					spu = append(spu, data[1:]...)
+					if size < 0 && len(spu) >= 2 {
+						size = int(spu[0])<<8 | int(spu[1])
+					}
+				}
+			}
+			i = end
+		}
+	}
+	return spu[:size], nil
+}
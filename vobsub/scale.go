@@ -0,0 +1,106 @@
+package vobsub
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+// Scale scales subtitles positioned on a screen of size from to a
+// screen of size to.
+func Scale(subs []pgs.Subtitle, from, to image.Point) []pgs.Subtitle {
+	scaled := make([]pgs.Subtitle, len(subs))
+	for i := range subs {
+		scaled[i] = *scaleSubtitle(&subs[i], from, to)
+	}
+	return scaled
+}
+
+func scaleSubtitle(s *pgs.Subtitle, from, to image.Point) *pgs.Subtitle {
+	b := s.Image.Bounds()
+	x, y := s.X, s.Y
+	w, h := b.Dx(), b.Dy()
+	if from != to {
+		x = (x*to.X + from.X/2) / from.X
+		y = (y*to.Y + from.Y/2) / from.Y
+		w = max((w*to.X+from.X/2)/from.X, 1)
+		h = max((h*to.Y+from.Y/2)/from.Y, 1)
+		// Keep rounding from moving the image off screen
+		if x+w > to.X {
+			x = max(to.X-w, 0)
+		}
+		if y+h > to.Y {
+			y = max(to.Y-h, 0)
+		}
+	}
+	return &pgs.Subtitle{
+		In:    s.In,
+		Out:   s.Out,
+		X:     x,
+		Y:     y,
+		Image: scale(s.Image, w, h),
+	}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// scale resamples an image to the given size by averaging the area of
+// the source covered by each pixel.
+func scale(img image.Image, width, height int) *image.RGBA {
+	src := image.NewRGBA(image.Rectangle{Max: img.Bounds().Size()})
+	draw.Draw(src, src.Rect, img, img.Bounds().Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if width == sw && height == sh {
+		return src
+	}
+	fx := float64(sw) / float64(width)
+	fy := float64(sh) / float64(height)
+	for y := 0; y < height; y++ {
+		y0, y1 := float64(y)*fy, float64(y+1)*fy
+		for x := 0; x < width; x++ {
+			x0, x1 := float64(x)*fx, float64(x+1)*fx
+			var sum [4]float64
+			var area float64
+			for sy := int(y0); float64(sy) < y1 && sy < sh; sy++ {
+				wy := overlap(y0, y1, sy)
+				for sx := int(x0); float64(sx) < x1 && sx < sw; sx++ {
+					w := wy * overlap(x0, x1, sx)
+					c := src.RGBAAt(sx, sy)
+					sum[0] += w * float64(c.R)
+					sum[1] += w * float64(c.G)
+					sum[2] += w * float64(c.B)
+					sum[3] += w * float64(c.A)
+					area += w
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				uint8(sum[0]/area + 0.5),
+				uint8(sum[1]/area + 0.5),
+				uint8(sum[2]/area + 0.5),
+				uint8(sum[3]/area + 0.5),
+			})
+		}
+	}
+	return dst
+}
+
+// overlap returns the length of the intersection of [a, b) with the
+// pixel [i, i+1).
+func overlap(a, b float64, i int) float64 {
+	lo, hi := float64(i), float64(i+1)
+	if a > lo {
+		lo = a
+	}
+	if b < hi {
+		hi = b
+	}
+	return hi - lo
+}
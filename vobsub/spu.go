@@ -0,0 +1,299 @@
+package vobsub
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"time"
+)
+
+// spu is a subpicture unit: a four color image with its position on
+// screen and the time it is shown.
+type spu struct {
+	X, Y     int
+	Image    *image.Paletted // Pixel values of background, pattern, emphasis 1, and emphasis 2
+	Colors   [4]uint8        // Palette index of each pixel value
+	Alpha    [4]uint8        // Opacity of each pixel value, from 0 to 15
+	Forced   bool
+	Duration time.Duration // Zero when shown until the next SPU
+}
+
+// SPU control commands
+const (
+	cmdForcedStart = 0x00
+	cmdStart       = 0x01
+	cmdStop        = 0x02
+	cmdColors      = 0x03
+	cmdAlpha       = 0x04
+	cmdCoords      = 0x05
+	cmdOffsets     = 0x06
+	cmdEnd         = 0xff
+)
+
+// Size of the arguments of each control command
+var cmdArgs = map[byte]int{cmdColors: 2, cmdAlpha: 2, cmdCoords: 6, cmdOffsets: 4}
+
+// encode encodes the SPU with the pixel data of the top field followed
+// by that of the bottom field, then the control sequences.
+func (s *spu) encode() ([]byte, error) {
+	size := s.Image.Rect.Size()
+	if size.X == 0 || size.Y == 0 {
+		return nil, fmt.Errorf("empty image: %dx%d", size.X, size.Y)
+	}
+	var w nibbleWriter
+	w.b = []byte{0, 0, 0, 0} // Sizes, set below
+	top := len(w.b)
+	for y := 0; y < size.Y; y += 2 {
+		w.line(s.Image, y)
+	}
+	bottom := len(w.b)
+	for y := 1; y < size.Y; y += 2 {
+		w.line(s.Image, y)
+	}
+	d := w.b
+
+	ctrl := len(d)
+	next := ctrl
+	if s.Duration > 0 {
+		next += 24
+	}
+	start := uint8(cmdStart)
+	if s.Forced {
+		start = cmdForcedStart
+	}
+	x1, y1 := s.X, s.Y
+	x2, y2 := s.X+size.X-1, s.Y+size.Y-1
+	if x2 > 0xfff || y2 > 0xfff {
+		return nil, fmt.Errorf("position out of range: (%d, %d)", x2, y2)
+	}
+	d = append(d,
+		0, 0, uint8(next>>8), uint8(next),
+		start,
+		cmdColors, s.Colors[emphasis2]<<4|s.Colors[emphasis1], s.Colors[pattern]<<4|s.Colors[background],
+		cmdAlpha, s.Alpha[emphasis2]<<4|s.Alpha[emphasis1], s.Alpha[pattern]<<4|s.Alpha[background],
+		cmdCoords, uint8(x1>>4), uint8(x1<<4|x2>>8), uint8(x2), uint8(y1>>4), uint8(y1<<4|y2>>8), uint8(y2),
+		cmdOffsets, uint8(top>>8), uint8(top), uint8(bottom>>8), uint8(bottom),
+		cmdEnd)
+	if s.Duration > 0 {
+		delay := (int64(s.Duration)*90/1e6 + delayTicks/2) / delayTicks
+		if delay > 0xffff {
+			return nil, fmt.Errorf("duration out of range: %s", s.Duration)
+		}
+		d = append(d, uint8(delay>>8), uint8(delay), uint8(next>>8), uint8(next), cmdStop, cmdEnd)
+	}
+	if len(d) > 0xffff {
+		return nil, fmt.Errorf("SPU size overflow: %d", len(d))
+	}
+	d[0], d[1] = uint8(len(d)>>8), uint8(len(d))
+	d[2], d[3] = uint8(ctrl>>8), uint8(ctrl)
+	return d, nil
+}
+
+// nibbleWriter writes 2-bit run-length encoded lines of nibbles.
+type nibbleWriter struct {
+	b    []byte
+	half bool // Low nibble of the last byte is next
+}
+
+func (w *nibbleWriter) put(n uint8) {
+	if w.half {
+		w.b[len(w.b)-1] |= n & 0xf
+	} else {
+		w.b = append(w.b, n<<4)
+	}
+	w.half = !w.half
+}
+
+// line encodes a line of the image, padded to a whole byte.
+func (w *nibbleWriter) line(img *image.Paletted, y int) {
+	width := img.Rect.Dx()
+	i := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+	row := img.Pix[i : i+width]
+	for x := 0; x < width; {
+		c := row[x] & 3
+		l := 1
+		for x+l < width && row[x+l]&3 == c && l < 0xff {
+			l++
+		}
+		w.run(c, l)
+		x += l
+	}
+	w.half = false
+}
+
+// run encodes l pixels of value c in the shortest code, where
+// 0 < l < 0x100.
+func (w *nibbleWriter) run(c uint8, l int) {
+	v := uint16(l)<<2 | uint16(c)
+	switch {
+	case l < 0x4: // LLCC
+		w.put(uint8(v))
+	case l < 0x10: // 00LL LLCC
+		w.put(uint8(v >> 4))
+		w.put(uint8(v))
+	case l < 0x40: // 0000 LLLL LLCC
+		w.put(uint8(v >> 8))
+		w.put(uint8(v >> 4))
+		w.put(uint8(v))
+	default: // 0000 00LL LLLL LLCC
+		w.put(uint8(v >> 12))
+		w.put(uint8(v >> 8))
+		w.put(uint8(v >> 4))
+		w.put(uint8(v))
+	}
+}
+
+// decodeSPU decodes an SPU and the delay from its presentation time
+// until display starts.
+func decodeSPU(d []byte) (s *spu, start time.Duration, err error) {
+	if len(d) < 4 {
+		return nil, 0, fmt.Errorf("SPU truncated: %d bytes", len(d))
+	}
+	size := int(d[0])<<8 | int(d[1])
+	if size > len(d) {
+		return nil, 0, fmt.Errorf("SPU has %d bytes, %d bytes declared", len(d), size)
+	}
+	d = d[:size]
+	s = &spu{}
+	var top, bottom int
+	var x2, y2 int
+	started, stopped := false, false
+	var stop time.Duration
+	for off := int(d[2])<<8 | int(d[3]); ; {
+		if off+4 > len(d) {
+			return nil, 0, fmt.Errorf("control sequence at %d truncated", off)
+		}
+		date := time.Duration(int(d[off])<<8|int(d[off+1])) * delayTicks * time.Millisecond / 90
+		next := int(d[off+2])<<8 | int(d[off+3])
+		i := off + 4
+	commands:
+		for {
+			if i >= len(d) {
+				return nil, 0, fmt.Errorf("control sequence at %d not ended", off)
+			}
+			cmd := d[i]
+			i++
+			args := cmdArgs[cmd]
+			if i+args > len(d) {
+				return nil, 0, fmt.Errorf("command 0x%02x truncated", cmd)
+			}
+			a := d[i : i+args]
+			switch cmd {
+			case cmdForcedStart, cmdStart:
+				if !started {
+					started = true
+					start = date
+				}
+				s.Forced = s.Forced || cmd == cmdForcedStart
+			case cmdStop:
+				if !stopped {
+					stopped = true
+					stop = date
+				}
+			case cmdColors:
+				s.Colors = [4]uint8{a[1] & 0xf, a[1] >> 4, a[0] & 0xf, a[0] >> 4}
+			case cmdAlpha:
+				s.Alpha = [4]uint8{a[1] & 0xf, a[1] >> 4, a[0] & 0xf, a[0] >> 4}
+			case cmdCoords:
+				s.X = int(a[0])<<4 | int(a[1])>>4
+				x2 = int(a[1]&0xf)<<8 | int(a[2])
+				s.Y = int(a[3])<<4 | int(a[4])>>4
+				y2 = int(a[4]&0xf)<<8 | int(a[5])
+			case cmdOffsets:
+				top = int(a[0])<<8 | int(a[1])
+				bottom = int(a[2])<<8 | int(a[3])
+			case 0x07: // Change color and contrast, skipped by its size
+				if i+2 > len(d) {
+					return nil, 0, fmt.Errorf("command 0x%02x truncated", cmd)
+				}
+				args = int(d[i])<<8 | int(d[i+1])
+			case cmdEnd:
+				break commands
+			default:
+				return nil, 0, fmt.Errorf("unrecognized control command: 0x%02x", cmd)
+			}
+			i += args
+		}
+		if next == off {
+			break
+		}
+		if next < off {
+			return nil, 0, fmt.Errorf("control sequence at %d links backwards to %d", off, next)
+		}
+		off = next
+	}
+	if stopped && stop > start {
+		s.Duration = stop - start
+	}
+
+	width, height := x2-s.X+1, y2-s.Y+1
+	if width <= 0 || height <= 0 {
+		return nil, 0, fmt.Errorf("invalid coordinates: (%d, %d) to (%d, %d)", s.X, s.Y, x2, y2)
+	}
+	s.Image = image.NewPaletted(image.Rect(0, 0, width, height), nil)
+	r := nibbleReader{b: d, i: 2 * top}
+	for y := 0; y < height; y += 2 {
+		if err := r.line(s.Image, y); err != nil {
+			return nil, 0, err
+		}
+	}
+	r = nibbleReader{b: d, i: 2 * bottom}
+	for y := 1; y < height; y += 2 {
+		if err := r.line(s.Image, y); err != nil {
+			return nil, 0, err
+		}
+	}
+	return s, start, nil
+}
+
+// nibbleReader reads 2-bit run-length encoded lines of nibbles.
+type nibbleReader struct {
+	b []byte
+	i int // Index of the next nibble
+}
+
+func (r *nibbleReader) get() (uint16, error) {
+	if r.i/2 >= len(r.b) {
+		return 0, errors.New("pixel data truncated")
+	}
+	n := r.b[r.i/2]
+	if r.i%2 == 0 {
+		n >>= 4
+	}
+	r.i++
+	return uint16(n & 0xf), nil
+}
+
+// line decodes a line of the image, which is padded to a whole byte.
+func (r *nibbleReader) line(img *image.Paletted, y int) error {
+	width := img.Rect.Dx()
+	row := img.Pix[img.PixOffset(0, y):][:width]
+	for x := 0; x < width; {
+		v, err := r.get()
+		if err != nil {
+			return err
+		}
+		// Read more nibbles while the code is below the minimum of its
+		// length
+		for _, min := range []uint16{0x4, 0x10, 0x40} {
+			if v >= min {
+				break
+			}
+			n, err := r.get()
+			if err != nil {
+				return err
+			}
+			v = v<<4 | n
+		}
+		l, c := int(v>>2), uint8(v&3)
+		if l == 0 || x+l > width { // Fill to the end of the line
+			l = width - x
+		}
+		for i := 0; i < l; i++ {
+			row[x+i] = c
+		}
+		x += l
+	}
+	r.i += r.i % 2
+	return nil
+}
@@ -0,0 +1,62 @@
+// Package vobsub converts between PGS streams and VobSub, the DVD
+// subtitle format of an .idx index and a .sub file of SPU packets in an
+// MPEG program stream.
+package vobsub
+
+import (
+	"fmt"
+	"image/color"
+	"time"
+)
+
+// DefaultPalette is the palette of 16 colors used when none is given.
+var DefaultPalette = color.Palette{
+	color.NRGBA{0x00, 0x00, 0x00, 0xff},
+	color.NRGBA{0xf0, 0xf0, 0xf0, 0xff},
+	color.NRGBA{0xcc, 0xcc, 0xcc, 0xff},
+	color.NRGBA{0x99, 0x99, 0x99, 0xff},
+	color.NRGBA{0x33, 0x33, 0xfa, 0xff},
+	color.NRGBA{0x11, 0x11, 0xbb, 0xff},
+	color.NRGBA{0xfa, 0x33, 0x33, 0xff},
+	color.NRGBA{0xbb, 0x11, 0x11, 0xff},
+	color.NRGBA{0x33, 0xfa, 0x33, 0xff},
+	color.NRGBA{0x11, 0xbb, 0x11, 0xff},
+	color.NRGBA{0xfa, 0xfa, 0x33, 0xff},
+	color.NRGBA{0xbb, 0xbb, 0x11, 0xff},
+	color.NRGBA{0xfa, 0x33, 0xfa, 0xff},
+	color.NRGBA{0xbb, 0x11, 0xbb, 0xff},
+	color.NRGBA{0x33, 0xfa, 0xfa, 0xff},
+	color.NRGBA{0x11, 0xbb, 0xbb, 0xff},
+}
+
+// The four colors of an SPU, in the order of their pixel values
+const (
+	background = iota
+	pattern
+	emphasis1
+	emphasis2
+)
+
+// ParseColors parses the palette indices of the background, pattern,
+// emphasis 1, and emphasis 2 colors, separated by commas.
+func ParseColors(s string) (*[4]uint8, error) {
+	var c [4]uint8
+	if n, err := fmt.Sscanf(s, "%d,%d,%d,%d", &c[0], &c[1], &c[2], &c[3]); err != nil || n != 4 {
+		return nil, fmt.Errorf("invalid colors: %q", s)
+	}
+	for _, i := range c {
+		if i >= 16 {
+			return nil, fmt.Errorf("color index out of range: %d", i)
+		}
+	}
+	return &c, nil
+}
+
+// Ticks of the 90 kHz clock per unit of SPU control sequence delays
+const delayTicks = 1024
+
+// timestamp formats a time as used in .idx files.
+func timestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d:%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
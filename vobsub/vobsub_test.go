@@ -0,0 +1,72 @@
+package vobsub
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/andrewarchi/transup/pgs"
+)
+
+func TestRoundTrip(t *testing.T) {
+	colors := []color.NRGBA{
+		{},
+		{0xf0, 0xf0, 0xf0, 0xff}, // Pattern
+		{0x00, 0x00, 0x00, 0xff}, // Emphasis 1
+		{0x99, 0x99, 0x99, 0xff}, // Emphasis 2
+	}
+	// Large enough to span several packs
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 61))
+	for y := 0; y < 61; y++ {
+		for x := 0; x < 300; x++ {
+			img.SetNRGBA(x, y, colors[(x/(y%5+1)+y)%4])
+		}
+	}
+	subs := []pgs.Subtitle{
+		{In: 1 * time.Second, Out: 3 * time.Second, X: 10, Y: 400, Image: img},
+		{In: 4 * time.Second, X: 20, Y: 410, Image: img.SubImage(image.Rect(0, 0, 5, 1))},
+	}
+	var idx, sub bytes.Buffer
+	screen := image.Pt(720, 480)
+	if err := Encode(&idx, &sub, subs, screen, &Options{Language: "en"}); err != nil {
+		t.Fatal(err)
+	}
+	if sub.Len()%packSize != 0 || sub.Len() <= 2*packSize {
+		t.Errorf(".sub has %d bytes, want multiple packs", sub.Len())
+	}
+	track, err := Decode(&idx, sub.Bytes(), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Language != "en" || track.Screen != screen || len(track.Subtitles) != len(subs) {
+		t.Fatalf("decoded track %s %v with %d subtitles", track.Language, track.Screen, len(track.Subtitles))
+	}
+	for i, got := range track.Subtitles {
+		want := subs[i]
+		// Stop delays are in units of 1024 ticks
+		out := got.Out - want.Out
+		if out < 0 {
+			out = -out
+		}
+		if got.In != want.In || out > delayTicks*time.Millisecond/90/2 || got.X != want.X || got.Y != want.Y {
+			t.Errorf("subtitle %d: got %s-%s at (%d, %d), want %s-%s at (%d, %d)",
+				i, got.In, got.Out, got.X, got.Y, want.In, want.Out, want.X, want.Y)
+		}
+		gb, wb := got.Image.Bounds(), want.Image.Bounds()
+		if gb.Size() != wb.Size() {
+			t.Errorf("subtitle %d: got size %v, want %v", i, gb.Size(), wb.Size())
+			continue
+		}
+		for y := 0; y < wb.Dy(); y++ {
+			for x := 0; x < wb.Dx(); x++ {
+				g := color.NRGBAModel.Convert(got.Image.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA)
+				w := color.NRGBAModel.Convert(want.Image.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA)
+				if g != w && !(g.A == 0 && w.A == 0) {
+					t.Fatalf("subtitle %d: pixel (%d, %d) is %v, want %v", i, x, y, g, w)
+				}
+			}
+		}
+	}
+}